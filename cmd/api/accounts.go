@@ -10,11 +10,24 @@ import (
 )
 
 func (app *application) createAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Depositors []int64 `json:"depositors"`
+	}
+
+	// the body is optional, an account can be opened before its depositors are known
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
 
 	requestingBank := app.contextGetBank(r)
 
 	account := &data.Account{
-		BankId: requestingBank.Id,
+		BankId:     requestingBank.Id,
+		Depositors: input.Depositors,
 	}
 
 	v := validator.New()
@@ -26,7 +39,13 @@ func (app *application) createAccountHandler(w http.ResponseWriter, r *http.Requ
 
 	err := app.models.Accounts.Insert(account)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDepositorNotFound):
+			v.AddError("depositors", "must only contain existing depositors")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -85,9 +104,15 @@ func (app *application) updateAccountHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if account.Status == data.AccountStatusClosed {
+		app.accountClosedResponse(w, r)
+		return
+	}
+
 	var input struct {
-		Frozen         *bool  `json:"frozen"`
-		BalanceInCents *int64 `json:"balance_in_cents"`
+		BalanceInCents      *int64 `json:"balance_in_cents"`
+		HoldingLimitInCents *int64 `json:"holding_limit_in_cents"`
+		OverflowAccountId   *int64 `json:"overflow_account_id"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -96,15 +121,17 @@ func (app *application) updateAccountHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if input.Frozen != nil {
-		account.Frozen = *input.Frozen
-	}
+	v := validator.New()
 
-	if input.BalanceInCents != nil {
-		account.BalanceInCents = *input.BalanceInCents
+	v.Check(input.BalanceInCents == nil, "balance_in_cents", "cannot be changed directly, use a transfer or another ledger operation")
+
+	if input.HoldingLimitInCents != nil {
+		account.HoldingLimitInCents = *input.HoldingLimitInCents
 	}
 
-	v := validator.New()
+	if input.OverflowAccountId != nil {
+		account.OverflowAccountId = *input.OverflowAccountId
+	}
 
 	if data.ValidateAccount(v, account); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -114,6 +141,68 @@ func (app *application) updateAccountHandler(w http.ResponseWriter, r *http.Requ
 	err = app.models.Accounts.Update(account, requestingBank.Id)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrOverflowAccountNotFound):
+			v.AddError("overflow_account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"account": account}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateAccountDepositorsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	account, err := app.models.Accounts.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Depositors []int64 `json:"depositors"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Depositors != nil, "depositors", "must be provided")
+
+	if data.ValidateDepositorIds(v, input.Depositors); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Accounts.SetDepositors(account, input.Depositors)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDepositorNotFound):
+			v.AddError("depositors", "must only contain existing depositors")
+			app.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -128,16 +217,134 @@ func (app *application) updateAccountHandler(w http.ResponseWriter, r *http.Requ
 	}
 }
 
-func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) closeAccountHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		SweepAccountId int64 `json:"sweep_account_id"`
+	}
+
+	// the body is optional, accounts with a zero balance don't need a sweep account
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	v.Check(input.SweepAccountId >= 0, "sweep_account_id", "must not be negative")
+
+	if v.Check(input.SweepAccountId != id, "sweep_account_id", "must be different from the account's id"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	account, err := app.models.Accounts.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if account.Status == data.AccountStatusClosed {
+		app.accountClosedResponse(w, r)
+		return
+	}
+
+	sweep, err := app.models.Accounts.Close(account, input.SweepAccountId, app.config.payments.frozenBankPolicy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrActiveHolds):
+			v.AddError("account", "must not have any holds in force")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrPendingAuthorizations):
+			v.AddError("account", "must not have any pending card authorizations, capture or void them first")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrNonZeroBalance):
+			v.AddError("sweep_account_id", "must be provided when the account's balance is not zero")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTargetAccountNotFound):
+			v.AddError("sweep_account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountClosed):
+			v.AddError("sweep_account_id", "must not be closed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrBankFrozen):
+			app.bankFrozenResponse(w, r)
+		case errors.Is(err, data.ErrTargetBankFrozen):
+			v.AddError("sweep_account_id", "belongs to a frozen bank")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycBalanceLimitExceeded), errors.Is(err, data.ErrHoldingLimitExceeded):
+			v.AddError("sweep_account_id", "must be able to take the account's whole balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientReserves):
+			v.AddError("sweep_account_id", "belongs to another bank and the bank's reserve balance can't settle the account's balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycTransactionLimitExceeded):
+			v.AddError("sweep_account_id", "can't be used, the balance exceeds the account's KYC transaction limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"account": account, "sweep": sweep}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) cashInHandler(w http.ResponseWriter, r *http.Request) {
+	app.cash(w, r, data.PostingKindCashIn)
+}
+
+func (app *application) cashOutHandler(w http.ResponseWriter, r *http.Request) {
+	app.cash(w, r, data.PostingKindCashOut)
+}
+
+func (app *application) cash(w http.ResponseWriter, r *http.Request, kind string) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
+	var input struct {
+		AmountInCents int64 `json:"amount_in_cents"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.AmountInCents > 0, "amount_in_cents", "must be greater than 0"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	requestingBank := app.contextGetBank(r)
 
-	err = app.models.Accounts.Delete(id, requestingBank.Id)
+	account, err := app.models.Accounts.Get(id, requestingBank.Id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -148,7 +355,45 @@ func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account successfully deleted"}, nil)
+	var posting *data.Posting
+
+	if kind == data.PostingKindCashIn {
+		posting, err = app.models.Accounts.CashIn(account, input.AmountInCents)
+	} else {
+		posting, err = app.models.Accounts.CashOut(account, input.AmountInCents)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAccountFrozen):
+			v.AddError("account", "must not be frozen")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountClosed):
+			v.AddError("account", "must not be closed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientReserves):
+			v.AddError("amount_in_cents", "must not exceed the bank's reserve balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycTransactionLimitExceeded):
+			v.AddError("amount_in_cents", "must not exceed the account's KYC transaction limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycBalanceLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the account over its KYC balance limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrHoldingLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the account over its holding limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientFunds):
+			v.AddError("amount_in_cents", "must not exceed the account's available balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"account": account, "posting": posting}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -156,7 +401,7 @@ func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Requ
 
 func (app *application) listAccountsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Frozen bool
+		Status string
 		data.Filters
 	}
 
@@ -166,20 +411,22 @@ func (app *application) listAccountsHandler(w http.ResponseWriter, r *http.Reque
 
 	qs := r.URL.Query()
 
-	input.Frozen = app.readBool(qs, "frozen", false, v)
+	input.Status = app.readString(qs, "status", "")
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "frozen", "-id", "-frozen"}
+	input.Filters.SortSafelist = []string{"id", "status", "balance_in_cents", "-id", "-status", "-balance_in_cents"}
+
+	v.Check(input.Status == "" || validator.PermittedValue(input.Status, data.AccountStatusActive, data.AccountStatusFrozen, data.AccountStatusClosed), "status", "must be active, frozen or closed")
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	accounts, metadata, err := app.models.Accounts.GetAll(requestingBank.Id, input.Filters)
+	accounts, metadata, err := app.models.Accounts.GetAll(requestingBank.Id, input.Status, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
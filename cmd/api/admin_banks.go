@@ -0,0 +1,443 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+// adminBank fetches the bank named by the id URL parameter, writing the error
+// response itself when it can't.
+func (app *application) adminBank(w http.ResponseWriter, r *http.Request) (*data.Bank, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	bank, err := app.models.Banks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return bank, true
+}
+
+func (app *application) adminCreateBankHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string   `json:"name"`
+		Email     string   `json:"email"`
+		Password  string   `json:"password"`
+		Role      string   `json:"role"`
+		SourceIps []string `json:"source_ips"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	bank := &data.Bank{
+		Name:      input.Name,
+		Email:     input.Email,
+		SourceIps: input.SourceIps,
+		Role:      input.Role,
+		Activated: false,
+	}
+
+	if bank.Role == "" {
+		bank.Role = data.RoleCommercialBank
+	}
+
+	err = bank.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateBank(v, bank)
+
+	if input.SourceIps != nil {
+		data.ValidateSourceIps(v, input.SourceIps)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkBankEmailFree(w, r, v, bank.Email) {
+		return
+	}
+
+	err = app.models.Banks.Insert(bank)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a bank with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateCentralBank):
+			v.AddError("role", "a central bank already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.sendBankWelcome(bank)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"bank": bank}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adminShowBankHandler(w http.ResponseWriter, r *http.Request) {
+	bank, ok := app.adminBank(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"bank": bank}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adminUpdateBankHandler(w http.ResponseWriter, r *http.Request) {
+	bank, ok := app.adminBank(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name      *string  `json:"name"`
+		Email     *string  `json:"email"`
+		Role      *string  `json:"role"`
+		SourceIps []string `json:"source_ips"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	v := validator.New()
+
+	if input.Name != nil {
+		bank.Name = *input.Name
+	}
+
+	emailChanged := input.Email != nil && *input.Email != bank.Email
+
+	if input.Email != nil {
+		bank.Email = *input.Email
+	}
+
+	if input.Role != nil {
+		v.Check(bank.Id != requestingBank.Id || *input.Role == bank.Role, "role", "can't be changed for the requesting bank")
+		bank.Role = *input.Role
+	}
+
+	if input.SourceIps != nil {
+		bank.SourceIps = input.SourceIps
+		data.ValidateSourceIps(v, bank.SourceIps)
+	}
+
+	if data.ValidateBank(v, bank); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if emailChanged && !app.checkBankEmailFree(w, r, v, bank.Email) {
+		return
+	}
+
+	err = app.models.Banks.Update(bank)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a bank with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateCentralBank):
+			v.AddError("role", "a central bank already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"bank": bank}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminActivateBankHandler activates a bank without it having to present the
+// token from its welcome email, for when that email never arrived.
+func (app *application) adminActivateBankHandler(w http.ResponseWriter, r *http.Request) {
+	bank, ok := app.adminBank(w, r)
+	if !ok {
+		return
+	}
+
+	if !bank.Activated {
+		bank.Activated = true
+
+		err := app.models.Banks.Update(bank)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.models.Tokens.DeleteAllForBank(data.ScopeActivation, bank.Id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"bank": bank}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adminFreezeBankHandler(w http.ResponseWriter, r *http.Request) {
+	app.setBankFrozen(w, r, true)
+}
+
+func (app *application) adminUnfreezeBankHandler(w http.ResponseWriter, r *http.Request) {
+	app.setBankFrozen(w, r, false)
+}
+
+// setBankFrozen freezes or unfreezes the bank named in the URL, recording the
+// reason given and the requesting central bank and user.
+func (app *application) setBankFrozen(w http.ResponseWriter, r *http.Request, frozen bool) {
+	bank, ok := app.adminBank(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(!frozen || bank.Role != data.RoleCentralBank, "id", "the central bank can't be frozen")
+
+	if data.ValidateBankFreezeReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	event, err := app.models.Banks.SetFrozen(bank, frozen, input.Reason, requestingBank.Id, app.contextGetUserId(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrBankAlreadyFrozen):
+			app.bankAlreadyFrozenResponse(w, r)
+		case errors.Is(err, data.ErrBankNotFrozen):
+			app.bankNotFrozenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"bank": bank, "freeze_event": event}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adminListBankFreezeEventsHandler(w http.ResponseWriter, r *http.Request) {
+	bank, ok := app.adminBank(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Banks.GetFreezeEvents(bank.Id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"freeze_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adminCreateBankBinHandler(w http.ResponseWriter, r *http.Request) {
+	bank, ok := app.adminBank(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Prefix     string `json:"prefix"`
+		CardLength int    `json:"card_length"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	bin := &data.Bin{
+		BankId:     bank.Id,
+		Prefix:     input.Prefix,
+		CardLength: input.CardLength,
+	}
+
+	v := validator.New()
+
+	if data.ValidateBin(v, bin); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Bins.Insert(bin)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrOverlappingBin):
+			v.AddError("prefix", "overlaps a range that is already assigned")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"bin": bin}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adminListBankBinsHandler(w http.ResponseWriter, r *http.Request) {
+	bank, ok := app.adminBank(w, r)
+	if !ok {
+		return
+	}
+
+	bins, err := app.models.Bins.GetAllForBank(bank.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"bins": bins}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) adminListBanksHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name   string
+		Email  string
+		Role   string
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+	input.Role = app.readString(qs, "role", "")
+	input.Status = app.readString(qs, "status", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "balance_in_cents", "-id", "-name", "-balance_in_cents"}
+
+	if input.Role != "" {
+		v.Check(validator.PermittedValue(input.Role, data.Roles...), "role", "must be one of central_bank, commercial_bank or psp")
+	}
+
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, data.BankStatusPending, data.BankStatusActive, data.BankStatusFrozen), "status", "must be one of pending, active or frozen")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	banks, metadata, err := app.models.Banks.GetAll(input.Name, input.Email, input.Role, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"banks": banks, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
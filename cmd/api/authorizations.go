@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+func (app *application) showAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	authorization, err := app.models.Authorizations.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization": authorization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) captureAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		AmountInCents int64 `json:"amount_in_cents"`
+	}
+
+	// the body is optional, leaving it out captures the full authorized amount
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if v.Check(input.AmountInCents >= 0, "amount_in_cents", "must not be negative"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	authorization := &data.Authorization{
+		Id:     id,
+		BankId: requestingBank.Id,
+	}
+
+	err = app.models.Authorizations.Capture(authorization, input.AmountInCents, app.config.payments.frozenBankPolicy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAuthorizationNotPending):
+			app.authorizationNotPendingResponse(w, r)
+		case errors.Is(err, data.ErrAuthorizationExpired):
+			v.AddError("authorization", "has expired, start a new payment")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCaptureExceedsAmount):
+			v.AddError("amount_in_cents", "must not exceed the authorized amount")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTargetAccountNotFound):
+			v.AddError("target_account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountFrozen):
+			v.AddError("account", "source account must not be frozen")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountClosed):
+			v.AddError("account", "source and target accounts must not be closed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrBankFrozen):
+			v.AddError("card_id", "card's bank is frozen")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTargetBankFrozen):
+			v.AddError("target_account_id", "belongs to a frozen bank")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycTransactionLimitExceeded):
+			v.AddError("amount_in_cents", "must not exceed the source account's KYC transaction limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycBalanceLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the target account over its KYC balance limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrHoldingLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the target account over its holding limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientFunds):
+			v.AddError("amount_in_cents", "must not exceed the card account's available balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientReserves):
+			v.AddError("amount_in_cents", "must not exceed the reserve balance the card's bank settles payments to other banks from")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization": authorization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) voidAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	authorization := &data.Authorization{
+		Id:     id,
+		BankId: requestingBank.Id,
+	}
+
+	err = app.models.Authorizations.Void(authorization)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAuthorizationNotPending):
+			app.authorizationNotPendingResponse(w, r)
+		case errors.Is(err, data.ErrAuthorizationExpired):
+			v := validator.New()
+			v.AddError("authorization", "has already expired and released its funds")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization": authorization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAuthorizationsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "amount_in_cents", "expires_at", "created_at", "-id", "-amount_in_cents", "-expires_at", "-created_at"}
+
+	v.Check(input.Status == "" || validator.PermittedValue(input.Status, data.AuthorizationStatusPending, data.AuthorizationStatusCaptured, data.AuthorizationStatusVoided, data.AuthorizationStatusExpired), "status", "must be pending, captured, voided or expired")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	authorizations, metadata, err := app.models.Authorizations.GetAll(requestingBank.Id, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorizations": authorizations, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/calmitchell617/reserva/internal/data"
 	"github.com/calmitchell617/reserva/internal/validator"
+	"github.com/tomasen/realip"
 )
 
 func (app *application) showBankHandler(w http.ResponseWriter, r *http.Request) {
@@ -29,11 +31,71 @@ func (app *application) showBankHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// updateBankSourceIpsHandler replaces the addresses the requesting bank's
+// tokens may be used from. The new list must still allow the address of this
+// request, so a bank can't lock itself out by mistake.
+func (app *application) updateBankSourceIpsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		SourceIps []string `json:"source_ips"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	bank, err := app.models.Banks.Get(requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	bank.SourceIps = input.SourceIps
+
+	v := validator.New()
+
+	data.ValidateSourceIps(v, bank.SourceIps)
+
+	if v.Valid() {
+		v.Check(bank.AllowsIP(realip.FromRequest(r)), "source_ips", "must include the address this request was made from")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Banks.Update(bank)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"bank": bank}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) registerBankHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Role     string `json:"role"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -45,9 +107,14 @@ func (app *application) registerBankHandler(w http.ResponseWriter, r *http.Reque
 	bank := &data.Bank{
 		Name:      input.Name,
 		Email:     input.Email,
+		Role:      input.Role,
 		Activated: false,
 	}
 
+	if bank.Role == "" {
+		bank.Role = data.RoleCommercialBank
+	}
+
 	err = bank.Password.Set(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -61,40 +128,140 @@ func (app *application) registerBankHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if !app.checkBankEmailFree(w, r, v, bank.Email) {
+		return
+	}
+
 	err = app.models.Banks.Insert(bank)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a bank with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateCentralBank):
+			v.AddError("role", "a central bank already exists")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	token, err := app.models.Tokens.New(bank.Id, 3*24*time.Hour, data.ScopeActivation)
+	err = app.sendBankWelcome(bank)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"bank": bank}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkBankEmailFree rejects an email that already belongs to a user, since
+// bank and user emails share one sign in. It writes the error response itself
+// and returns false when the email can't be used.
+func (app *application) checkBankEmailFree(w http.ResponseWriter, r *http.Request, v *validator.Validator, email string) bool {
+	_, err := app.models.Users.GetByEmail(email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	return true
+}
+
+// sendBankWelcome emails a newly created bank its id and an activation token in
+// the background.
+func (app *application) sendBankWelcome(bank *data.Bank) error {
+	token, err := app.models.Tokens.New(bank.Id, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
 	app.background(func() {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
 			"bankID":          bank.Id,
 		}
 
-		err = app.mailer.Send(bank.Email, "bank_welcome.tmpl", data)
+		err := app.mailer.Send(bank.Email, "bank_welcome.tmpl", data)
 		if err != nil {
 			app.logger.PrintError(err, nil)
 		}
 	})
 
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"bank": bank}, nil)
+	return nil
+}
+
+// bootstrapCentralBank creates the central bank named by the -central-bank-*
+// flags, so a fresh deployment has someone to onboard the other banks through
+// the admin API. It does nothing once a central bank exists.
+func (app *application) bootstrapCentralBank() error {
+	if app.config.centralBank.email == "" {
+		return nil
+	}
+
+	exists, err := app.models.Banks.CentralBankExists()
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		return err
 	}
+
+	if exists {
+		app.logger.PrintInfo("central bank already exists", nil)
+		return nil
+	}
+
+	bank := &data.Bank{
+		Name:  app.config.centralBank.name,
+		Email: app.config.centralBank.email,
+		Role:  data.RoleCentralBank,
+	}
+
+	err = bank.Password.Set(app.config.centralBank.password)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	if data.ValidateBank(v, bank); !v.Valid() {
+		return fmt.Errorf("invalid central bank flags: %v", v.Errors)
+	}
+
+	_, err = app.models.Users.GetByEmail(bank.Email)
+	switch {
+	case err == nil:
+		return fmt.Errorf("invalid central bank flags: a user with the email %s already exists", bank.Email)
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return err
+	}
+
+	err = app.models.Banks.Insert(bank)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCentralBank):
+			// another instance created it since the check above
+			app.logger.PrintInfo("central bank already exists", nil)
+			return nil
+		case errors.Is(err, data.ErrDuplicateName):
+			return fmt.Errorf("invalid central bank flags: a bank named %s already exists", bank.Name)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			return fmt.Errorf("invalid central bank flags: a bank with the email %s already exists", bank.Email)
+		default:
+			return err
+		}
+	}
+
+	app.logger.PrintInfo("central bank created", map[string]string{"email": bank.Email})
+
+	return app.sendBankWelcome(bank)
 }
 
 func (app *application) activateBankHandler(w http.ResponseWriter, r *http.Request) {
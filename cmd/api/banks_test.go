@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/calmitchell617/reserva/internal/data"
+)
+
+func TestBootstrapCentralBank(t *testing.T) {
+	app := newTestApplication(t)
+
+	app.config.centralBank.name = "Central"
+	app.config.centralBank.email = "central@example.com"
+	app.config.centralBank.password = "pa55word1234"
+
+	// a restart with the same flags must leave the central bank alone
+	for i := 0; i < 2; i++ {
+		err := app.bootstrapCentralBank()
+		if err != nil {
+			t.Fatalf("bootstrapCentralBank() call %d error = %v", i+1, err)
+		}
+	}
+
+	bank, err := app.models.Banks.GetByEmail("central@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bank.Role != data.RoleCentralBank {
+		t.Errorf("Role = %q, want %q", bank.Role, data.RoleCentralBank)
+	}
+
+	exists, err := app.models.Banks.CentralBankExists()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !exists {
+		t.Error("CentralBankExists() = false, want true")
+	}
+}
+
+func TestBootstrapCentralBankDuplicateName(t *testing.T) {
+	app := newTestApplication(t)
+
+	bank := &data.Bank{Name: "Central", Email: "commercial@example.com", Role: data.RoleCommercialBank}
+
+	err := bank.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Banks.Insert(bank)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.config.centralBank.name = "Central"
+	app.config.centralBank.email = "central@example.com"
+	app.config.centralBank.password = "pa55word1234"
+
+	err = app.bootstrapCentralBank()
+	if err == nil || !strings.Contains(err.Error(), "a bank named Central already exists") {
+		t.Fatalf("bootstrapCentralBank() error = %v, want a duplicate name error", err)
+	}
+}
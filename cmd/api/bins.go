@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/calmitchell617/reserva/internal/data"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listBinsHandler(w http.ResponseWriter, r *http.Request) {
+	requestingBank := app.contextGetBank(r)
+
+	bins, err := app.models.Bins.GetAllForBank(requestingBank.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"bins": bins}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showBinHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	number, err := strconv.ParseInt(params.ByName("number"), 10, 64)
+	if err != nil || number < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	bin, err := app.models.Bins.GetForCardNumber(number)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"bin": bin}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
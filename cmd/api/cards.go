@@ -11,11 +11,90 @@ import (
 	"github.com/calmitchell617/reserva/internal/validator"
 )
 
+type cardInput struct {
+	AccountId    int64  `json:"account_id"`
+	Password     string `json:"password"`
+	ExpiryInDays int    `json:"expiry_in_days"`
+}
+
+// personalizedCard pairs a new card with its private key. It is only ever
+// written in the response to the request that created the card.
+type personalizedCard struct {
+	Card       *data.Card         `json:"card"`
+	PrivateKey ed25519.PrivateKey `json:"private_key"`
+}
+
+func (app *application) newCard(input cardInput) (*personalizedCard, error) {
+	card := &data.Card{
+		AccountId: input.AccountId,
+		Expiry:    time.Now().AddDate(0, 0, input.ExpiryInDays),
+	}
+
+	err := card.Password.Set(input.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	card.PublicKey = publicKey
+
+	return &personalizedCard{Card: card, PrivateKey: privateKey}, nil
+}
+
+// cardBin looks up the range a bank's new cards are numbered from. A binId of
+// 0 selects the bank's default range. It writes an error response and returns
+// false if there is no such range.
+func (app *application) cardBin(w http.ResponseWriter, r *http.Request, binId int64, bankId int64) (*data.Bin, bool) {
+	bin, err := app.models.Bins.Get(binId, bankId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound) && binId == 0:
+			// banks without a range of their own keep issuing from the legacy one
+			legacy := data.LegacyBin
+			return &legacy, true
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"bin_id": "does not exist"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return bin, true
+}
+
+// insertCards stores cards, drawing fresh card numbers from bin and retrying
+// if a generated number is already taken.
+func (app *application) insertCards(cards []*data.Card, bin *data.Bin, bankId int64) error {
+	for i := 0; i < 5; i++ {
+		for _, card := range cards {
+			number, err := generateCardNumber(bin)
+			if err != nil {
+				return err
+			}
+
+			card.Id = number
+		}
+
+		err := app.models.Cards.InsertBatch(cards, bankId)
+		if errors.Is(err, data.ErrDuplicateCardId) {
+			continue
+		}
+
+		return err
+	}
+
+	return errors.New("couldn't generate a unique card number")
+}
+
 func (app *application) createCardHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		AccountId    int64  `json:"account_id"`
-		Password     string `json:"password"`
-		ExpiryInDays int    `json:"expiry_in_days"`
+		cardInput
+		BinId int64 `json:"bin_id"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -24,68 +103,318 @@ func (app *application) createCardHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	expiry := time.Now().AddDate(0, 0, input.ExpiryInDays)
+	personalized, err := app.newCard(input.cardInput)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	card := &data.Card{
-		AccountId: input.AccountId,
-		Expiry:    expiry,
+	v := validator.New()
+
+	if data.ValidateCard(v, personalized.Card); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	err = card.Password.Set(input.Password)
+	requestingBank := app.contextGetBank(r)
+
+	bin, ok := app.cardBin(w, r, input.BinId, requestingBank.Id)
+	if !ok {
+		return
+	}
+
+	err = app.insertCards([]*data.Card{personalized.Card}, bin, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/cards/%d", personalized.Card.Id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"card": personalized.Card, "private_key": personalized.PrivateKey}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCardBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		BinId int64       `json:"bin_id"`
+		Cards []cardInput `json:"cards"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
 	v := validator.New()
 
-	if data.ValidateCard(v, card); !v.Valid() {
+	v.Check(len(input.Cards) > 0, "cards", "must contain at least 1 card")
+	v.Check(len(input.Cards) <= 20, "cards", "must not contain more than 20 cards")
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	_, privateKey, err := ed25519.GenerateKey(nil)
+	batch := make([]*personalizedCard, len(input.Cards))
+	cards := make([]*data.Card, len(input.Cards))
+
+	for i := range input.Cards {
+		batch[i], err = app.newCard(input.Cards[i])
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		cards[i] = batch[i].Card
+
+		cardValidator := validator.New()
+		data.ValidateCard(cardValidator, cards[i])
+
+		for key, message := range cardValidator.Errors {
+			v.AddError(fmt.Sprintf("cards[%d].%s", i, key), message)
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	bin, ok := app.cardBin(w, r, input.BinId, requestingBank.Id)
+	if !ok {
+		return
+	}
+
+	err = app.insertCards(cards, bin, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("cards", "every account_id must exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"cards": batch}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCardHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	card, err := app.models.Cards.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"card": card}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
 
-	card.PrivateKey = privateKey
+func (app *application) listCardsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		AccountId int64
+		Status    string
+		data.Filters
+	}
 
 	requestingBank := app.contextGetBank(r)
 
-	card.Id, err = generateCardNumber()
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.AccountId = app.readInt64(qs, "account_id", 0, v)
+	input.Status = app.readString(qs, "status", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "account_id", "expiry", "status", "-id", "-account_id", "-expiry", "-status"}
+
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, data.CardStatusActive, data.CardStatusBlocked, data.CardStatusReplaced), "status", "must be one of active, blocked or replaced")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cards, metadata, err := app.models.Cards.GetAll(requestingBank.Id, input.AccountId, input.Status, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	for i := 0; i < 5; i++ {
-		err = app.models.Cards.Insert(card, requestingBank.Id)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrDuplicateCardId):
-				if i == 4 {
-					app.serverErrorResponse(w, r, errors.New("couldn't generate a unique card number"))
-					return
-				}
-				continue
-			default:
-				app.serverErrorResponse(w, r, err)
-				return
-			}
+	err = app.writeJSON(w, http.StatusOK, envelope{"cards": cards, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCardPinHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	card, err := app.models.Cards.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
-		break
+		return
 	}
 
-	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/cards/%d", card.Id))
+	if card.Status != data.CardStatusActive {
+		v.AddError("card", "must be active to change its PIN")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = card.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Cards.Update(card, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"card": card}, headers)
+	err = app.writeJSON(w, http.StatusOK, envelope{"card": card}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) showCardHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) blockCardHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateBlockedReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	card, err := app.models.Cards.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if card.Status != data.CardStatusActive {
+		v.AddError("card", "must be active to be blocked")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	card.Status = data.CardStatusBlocked
+	card.BlockedReason = input.Reason
+
+	err = app.models.Cards.Update(card, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"card": card}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unlockCardHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
@@ -94,7 +423,7 @@ func (app *application) showCardHandler(w http.ResponseWriter, r *http.Request)
 
 	requestingBank := app.contextGetBank(r)
 
-	account, err := app.models.Accounts.Get(id, requestingBank.Id)
+	card, err := app.models.Cards.Get(id, requestingBank.Id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -105,134 +434,103 @@ func (app *application) showCardHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"account": account}, nil)
+	card.Locked = false
+	card.FailedPinAttempts = 0
+
+	err = app.models.Cards.Update(card, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"card": card}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// func (app *application) updateAccountHandler(w http.ResponseWriter, r *http.Request) {
-// 	id, err := app.readIDParam(r)
-// 	if err != nil {
-// 		app.notFoundResponse(w, r)
-// 		return
-// 	}
-
-// 	requestingBank := app.contextGetBank(r)
-
-// 	account, err := app.models.Accounts.Get(id, requestingBank.Id)
-// 	if err != nil {
-// 		switch {
-// 		case errors.Is(err, data.ErrRecordNotFound):
-// 			app.notFoundResponse(w, r)
-// 		default:
-// 			app.serverErrorResponse(w, r, err)
-// 		}
-// 		return
-// 	}
-
-// 	var input struct {
-// 		Frozen         *bool  `json:"frozen"`
-// 		BalanceInCents *int64 `json:"balance_in_cents"`
-// 	}
-
-// 	err = app.readJSON(w, r, &input)
-// 	if err != nil {
-// 		app.badRequestResponse(w, r, err)
-// 		return
-// 	}
-
-// 	if input.Frozen != nil {
-// 		account.Frozen = *input.Frozen
-// 	}
-
-// 	if input.BalanceInCents != nil {
-// 		account.BalanceInCents = *input.BalanceInCents
-// 	}
-
-// 	v := validator.New()
-
-// 	if data.ValidateAccount(v, account); !v.Valid() {
-// 		app.failedValidationResponse(w, r, v.Errors)
-// 		return
-// 	}
-
-// 	err = app.models.Accounts.Update(account, requestingBank.Id)
-// 	if err != nil {
-// 		switch {
-// 		case errors.Is(err, data.ErrEditConflict):
-// 			app.editConflictResponse(w, r)
-// 		default:
-// 			app.serverErrorResponse(w, r, err)
-// 		}
-// 		return
-// 	}
-
-// 	err = app.writeJSON(w, http.StatusOK, envelope{"account": account}, nil)
-// 	if err != nil {
-// 		app.serverErrorResponse(w, r, err)
-// 	}
-// }
-
-// func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
-// 	id, err := app.readIDParam(r)
-// 	if err != nil {
-// 		app.notFoundResponse(w, r)
-// 		return
-// 	}
-
-// 	requestingBank := app.contextGetBank(r)
-
-// 	err = app.models.Accounts.Delete(id, requestingBank.Id)
-// 	if err != nil {
-// 		switch {
-// 		case errors.Is(err, data.ErrRecordNotFound):
-// 			app.notFoundResponse(w, r)
-// 		default:
-// 			app.serverErrorResponse(w, r, err)
-// 		}
-// 		return
-// 	}
-
-// 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account successfully deleted"}, nil)
-// 	if err != nil {
-// 		app.serverErrorResponse(w, r, err)
-// 	}
-// }
-
-// func (app *application) listAccountsHandler(w http.ResponseWriter, r *http.Request) {
-// 	var input struct {
-// 		Frozen bool
-// 		data.Filters
-// 	}
-
-// 	requestingBank := app.contextGetBank(r)
-
-// 	v := validator.New()
-
-// 	qs := r.URL.Query()
-
-// 	input.Frozen = app.readBool(qs, "frozen", false, v)
-
-// 	input.Filters.Page = app.readInt(qs, "page", 1, v)
-// 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-
-// 	input.Filters.Sort = app.readString(qs, "sort", "id")
-// 	input.Filters.SortSafelist = []string{"id", "frozen", "-id", "-frozen"}
-
-// 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-// 		app.failedValidationResponse(w, r, v.Errors)
-// 		return
-// 	}
-
-// 	accounts, metadata, err := app.models.Accounts.GetAll(requestingBank.Id, input.Filters)
-// 	if err != nil {
-// 		app.serverErrorResponse(w, r, err)
-// 		return
-// 	}
-
-// 	err = app.writeJSON(w, http.StatusOK, envelope{"accounts": accounts, "metadata": metadata}, nil)
-// 	if err != nil {
-// 		app.serverErrorResponse(w, r, err)
-// 	}
-// }
+func (app *application) replaceCardHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		BinId        int64 `json:"bin_id"`
+		ExpiryInDays int   `json:"expiry_in_days"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.ExpiryInDays > 0, "expiry_in_days", "must be greater than 0"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	bin, ok := app.cardBin(w, r, input.BinId, requestingBank.Id)
+	if !ok {
+		return
+	}
+
+	card := &data.Card{Id: id}
+	replacement := &data.Card{
+		PublicKey: publicKey,
+		Expiry:    time.Now().AddDate(0, 0, input.ExpiryInDays),
+	}
+
+	for i := 0; i < 5; i++ {
+		replacement.Id, err = generateCardNumber(bin)
+		if err != nil {
+			break
+		}
+
+		err = app.models.Cards.Replace(card, replacement, requestingBank.Id)
+		if !errors.Is(err, data.ErrDuplicateCardId) {
+			break
+		}
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrCardInactive):
+			v.AddError("card", "has already been replaced")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountClosed):
+			v.AddError("card", "belongs to a closed account")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/cards/%d", replacement.Id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"card": replacement, "private_key": privateKey, "replaced_card": card}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
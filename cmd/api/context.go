@@ -9,7 +9,10 @@ import (
 
 type contextKey string
 
-const bankContextKey = contextKey("bank")
+const (
+	bankContextKey = contextKey("bank")
+	userContextKey = contextKey("user")
+)
 
 func (app *application) contextSetBank(r *http.Request, bank *data.Bank) *http.Request {
 	ctx := context.WithValue(r.Context(), bankContextKey, bank)
@@ -24,3 +27,25 @@ func (app *application) contextGetBank(r *http.Request) *data.Bank {
 
 	return bank
 }
+
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser returns the user making the request, or nil if it was made
+// with the bank's own credentials.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, _ := r.Context().Value(userContextKey).(*data.User)
+	return user
+}
+
+// contextGetUserId returns the id of the user making the request, or 0 if it
+// was made with the bank's own credentials.
+func (app *application) contextGetUserId(r *http.Request) int64 {
+	if user := app.contextGetUser(r); user != nil {
+		return user.Id
+	}
+
+	return 0
+}
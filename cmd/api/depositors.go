@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+func (app *application) createDepositorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name       string `json:"name"`
+		Email      string `json:"email"`
+		ExternalId string `json:"external_id"`
+		KycTier    string `json:"kyc_tier"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	depositor := &data.Depositor{
+		BankId:     requestingBank.Id,
+		Name:       input.Name,
+		Email:      input.Email,
+		ExternalId: input.ExternalId,
+		KycTier:    input.KycTier,
+	}
+
+	if depositor.KycTier == "" {
+		depositor.KycTier = data.KycTierAnonymous
+	}
+
+	v := validator.New()
+
+	if data.ValidateDepositor(v, depositor); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Depositors.Insert(depositor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/depositors/%d", depositor.Id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"depositor": depositor}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showDepositorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	depositor, err := app.models.Depositors.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"depositor": depositor}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateDepositorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	depositor, err := app.models.Depositors.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name       *string `json:"name"`
+		Email      *string `json:"email"`
+		ExternalId *string `json:"external_id"`
+		KycTier    *string `json:"kyc_tier"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		depositor.Name = *input.Name
+	}
+
+	if input.Email != nil {
+		depositor.Email = *input.Email
+	}
+
+	if input.ExternalId != nil {
+		depositor.ExternalId = *input.ExternalId
+	}
+
+	if input.KycTier != nil {
+		depositor.KycTier = *input.KycTier
+	}
+
+	v := validator.New()
+
+	if data.ValidateDepositor(v, depositor); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Depositors.Update(depositor)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"depositor": depositor}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteDepositorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	err = app.models.Depositors.Delete(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "depositor successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listDepositorsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name  string
+		Email string
+		data.Filters
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "created_at", "-id", "-name", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	depositors, metadata, err := app.models.Depositors.GetAll(requestingBank.Id, input.Name, input.Email, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"depositors": depositors, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
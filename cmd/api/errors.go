@@ -79,7 +79,67 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) sourceIPNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "requests for your bank are not allowed from this IP address"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your bank account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) bankFrozenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your bank is frozen by the central bank and may only make read requests"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) bankAlreadyFrozenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this bank is already frozen"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) bankNotFrozenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this bank is not frozen"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) accountClosedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this account is closed and can no longer be changed"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) holdNotActiveResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this hold has already been released or has expired"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) authorizationNotPendingResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this authorization has already been captured or voided"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) transferRefundedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this transfer has nothing left to refund"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) scheduledTransferNotActiveResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this scheduled transfer has already completed or been cancelled"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) paymentNotPendingResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this payment has already been completed or has expired"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this idempotency key is still being processed, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this idempotency key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
@@ -11,7 +11,9 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/calmitchell617/reserva/internal/data"
 	"github.com/calmitchell617/reserva/internal/validator"
 	"github.com/theplant/luhn"
 
@@ -19,11 +21,15 @@ import (
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -154,6 +160,38 @@ func (app *application) readBool(qs url.Values, key string, defaultValue bool, v
 	return i
 }
 
+func (app *application) readInt64(qs url.Values, key string, defaultValue int64, v *validator.Validator) int64 {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return nil
+	}
+
+	return &t
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 
@@ -171,11 +209,14 @@ func (app *application) background(fn func()) {
 	}()
 }
 
-func generateCardNumber() (int64, error) {
+// generateCardNumber draws a random card number from bin's range. The number
+// is bin.CardLength digits long, starts with bin.Prefix and ends with a Luhn
+// check digit.
+func generateCardNumber(bin *data.Bin) (int64, error) {
 	var cardNumber int64
-	cardString := "29"
+	cardString := bin.Prefix
 
-	for i := 0; i < 13; i++ {
+	for len(cardString) < bin.CardLength-1 {
 		nBig, err := rand.Int(rand.Reader, big.NewInt(10))
 		if err != nil {
 			return cardNumber, err
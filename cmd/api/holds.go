@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+func (app *application) createHoldHandler(w http.ResponseWriter, r *http.Request) {
+	accountId, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Kind          string     `json:"kind"`
+		AmountInCents int64      `json:"amount_in_cents"`
+		ReasonCode    string     `json:"reason_code"`
+		Authority     string     `json:"authority"`
+		StartsAt      *time.Time `json:"starts_at"`
+		ExpiresAt     *time.Time `json:"expires_at"`
+		Note          string     `json:"note"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hold := &data.Hold{
+		AccountId:     accountId,
+		Kind:          input.Kind,
+		AmountInCents: input.AmountInCents,
+		ReasonCode:    input.ReasonCode,
+		Authority:     input.Authority,
+		StartsAt:      time.Now(),
+		ExpiresAt:     input.ExpiresAt,
+	}
+
+	if input.StartsAt != nil {
+		hold.StartsAt = *input.StartsAt
+	}
+
+	v := validator.New()
+
+	data.ValidateHoldNote(v, input.Note)
+
+	if data.ValidateHold(v, hold); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	err = app.models.Holds.Insert(hold, requestingBank.Id, input.Note, app.contextGetUserId(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAccountClosed):
+			app.accountClosedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/accounts/%d/holds/%d", accountId, hold.Id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"hold": hold}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showHoldHandler(w http.ResponseWriter, r *http.Request) {
+	accountId, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	holdId, err := app.readNamedIDParam(r, "hold_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	hold, err := app.models.Holds.Get(holdId, accountId, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"hold": hold}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) releaseHoldHandler(w http.ResponseWriter, r *http.Request) {
+	accountId, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	holdId, err := app.readNamedIDParam(r, "hold_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Note string `json:"note"`
+	}
+
+	// the body is optional, a hold can be released without a note
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateHoldNote(v, input.Note); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	hold, err := app.models.Holds.Get(holdId, accountId, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Holds.Release(hold, input.Note, app.contextGetUserId(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrHoldNotActive):
+			app.holdNotActiveResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"hold": hold}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	accountId, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Active bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Active = app.readBool(qs, "active", false, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "starts_at", "created_at", "-id", "-starts_at", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	_, err = app.models.Accounts.Get(accountId, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	holds, metadata, err := app.models.Holds.GetAll(accountId, requestingBank.Id, input.Active, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"holds": holds, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
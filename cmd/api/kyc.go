@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listKycTiersHandler(w http.ResponseWriter, r *http.Request) {
+	tiers, err := app.models.KycTiers.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"kyc_tiers": tiers}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateKycTierHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	tier, err := app.models.KycTiers.Get(params.ByName("tier"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		MaxBalanceInCents     *int64 `json:"max_balance_in_cents"`
+		MaxTransactionInCents *int64 `json:"max_transaction_in_cents"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.MaxBalanceInCents != nil {
+		tier.MaxBalanceInCents = *input.MaxBalanceInCents
+	}
+
+	if input.MaxTransactionInCents != nil {
+		tier.MaxTransactionInCents = *input.MaxTransactionInCents
+	}
+
+	v := validator.New()
+
+	if data.ValidateKycTier(v, tier); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.KycTiers.Update(tier)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"kyc_tier": tier}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
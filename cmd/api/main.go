@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -57,6 +58,25 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	payments struct {
+		challengeTTL     time.Duration
+		maxPinAttempts   int
+		authorizationTTL time.Duration
+		frozenBankPolicy string
+	}
+	scheduler struct {
+		interval   time.Duration
+		maxRetries int
+		retryDelay time.Duration
+	}
+	centralBank struct {
+		name     string
+		email    string
+		password string
+	}
 }
 
 type application struct {
@@ -98,6 +118,29 @@ func main() {
 		return nil
 	})
 
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-key-ttl", 24*time.Hour, "How long responses are kept for replay under an Idempotency-Key")
+
+	flag.DurationVar(&cfg.payments.challengeTTL, "payment-challenge-ttl", 2*time.Minute, "How long a card has to answer a payment challenge")
+	flag.IntVar(&cfg.payments.maxPinAttempts, "card-max-pin-attempts", 3, "Consecutive incorrect PINs before a card is locked")
+	flag.DurationVar(&cfg.payments.authorizationTTL, "authorization-ttl", 7*24*time.Hour, "How long an uncaptured card authorization reserves funds")
+
+	cfg.payments.frozenBankPolicy = data.FrozenBankPaymentsAccept
+	flag.Func("frozen-bank-payments", "Payments and transfers into a frozen bank's accounts (accept|reject, default accept)", func(val string) error {
+		if val != data.FrozenBankPaymentsAccept && val != data.FrozenBankPaymentsReject {
+			return errors.New("must be accept or reject")
+		}
+		cfg.payments.frozenBankPolicy = val
+		return nil
+	})
+
+	flag.DurationVar(&cfg.scheduler.interval, "scheduler-interval", time.Minute, "How often due scheduled transfers are run")
+	flag.IntVar(&cfg.scheduler.maxRetries, "scheduled-transfer-max-retries", 3, "Retries of a scheduled transfer refused for insufficient funds")
+	flag.DurationVar(&cfg.scheduler.retryDelay, "scheduled-transfer-retry-delay", time.Hour, "How long to wait before retrying a scheduled transfer")
+
+	flag.StringVar(&cfg.centralBank.name, "central-bank-name", "", "Name of the central bank to create on first start")
+	flag.StringVar(&cfg.centralBank.email, "central-bank-email", "", "Email of the central bank to create on first start")
+	flag.StringVar(&cfg.centralBank.password, "central-bank-password", "", "Password of the central bank to create on first start")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -139,6 +182,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	if cfg.payments.maxPinAttempts < 1 {
+		fmt.Println("The card max PIN attempts must be at least 1")
+		os.Exit(1)
+	}
+
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
 	writeDb, readDb, err := openDB(cfg)
@@ -175,6 +223,11 @@ func main() {
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender, cfg.env),
 	}
 
+	err = app.bootstrapCentralBank()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"expvar"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -112,7 +116,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		bank, err := app.models.Banks.GetForToken(data.ScopeAuthentication, token)
+		bank, user, err := app.bankForToken(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -123,12 +127,46 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if !bank.AllowsIP(realip.FromRequest(r)) {
+			app.sourceIPNotAllowedResponse(w, r)
+			return
+		}
+
 		r = app.contextSetBank(r, bank)
 
+		if user != nil {
+			r = app.contextSetUser(r, user)
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// bankForToken resolves an authentication token to the bank it acts for and,
+// if it was issued to one of the bank's users, that user.
+func (app *application) bankForToken(token string) (*data.Bank, *data.User, error) {
+	user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+
+		bank, err := app.models.Banks.GetForToken(data.ScopeAuthentication, token)
+		return bank, nil, err
+	}
+
+	bank, err := app.models.Banks.Get(user.BankId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bank, user, nil
+}
+
+func readOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
 func (app *application) requireAuthenticatedBank(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		bank := app.contextGetBank(r)
@@ -146,17 +184,286 @@ func (app *application) requireActivatedBank(next http.HandlerFunc) http.Handler
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		bank := app.contextGetBank(r)
 
-		if !bank.Activated {
+		user := app.contextGetUser(r)
+
+		if !bank.Activated || (user != nil && !user.Activated) {
 			app.inactiveAccountResponse(w, r)
 			return
 		}
 
+		// frozen banks keep read access, so they can see where they stand
+		if bank.Frozen && !readOnlyMethod(r.Method) {
+			app.bankFrozenResponse(w, r)
+			return
+		}
+
+		if user != nil && user.Role == data.UserRoleReadOnly && !readOnlyMethod(r.Method) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 
 	return app.requireAuthenticatedBank(fn)
 }
 
+func (app *application) requirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bank := app.contextGetBank(r)
+
+		if !bank.Can(permission) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireActivatedBank(fn)
+}
+
+// requireUserAdmin lets through admin users and requests made with the bank's
+// own credentials.
+func (app *application) requireUserAdmin(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user != nil && user.Role != data.UserRoleAdmin {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireActivatedBank(fn)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	return rec.ResponseWriter.Write(b)
+}
+
+// audit records every write request an authenticated bank makes, along with
+// the user who made it and the response status.
+func (app *application) audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bank := app.contextGetBank(r)
+
+		if bank.IsAnonymous() || readOnlyMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		event := &data.AuditEvent{
+			BankId: bank.Id,
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Status: rec.status,
+		}
+
+		if user := app.contextGetUser(r); user != nil {
+			event.UserId = user.Id
+		}
+
+		err := app.models.AuditEvents.Insert(event)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	withheld bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// stored returns the response to keep for replays. A withheld response is
+// never kept, only a conflict saying so, because it carries a secret that must
+// not be written to the database or sent twice.
+func (rec *idempotencyRecorder) stored() (int, http.Header, []byte) {
+	if !rec.withheld {
+		return rec.status, rec.Header().Clone(), rec.body.Bytes()
+	}
+
+	message := "this idempotency key has already been used and its response can't be sent again"
+
+	js, _ := json.MarshalIndent(envelope{"error": message}, "", "\t")
+	js = append(js, '\n')
+
+	return http.StatusConflict, http.Header{"Content-Type": {"application/json"}}, js
+}
+
+// withholdFromReplay marks responses of next as carrying secrets, such as card
+// private keys. The idempotency middleware still records the key, but a retry
+// gets a conflict instead of the original response.
+func (app *application) withholdFromReplay(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rec, ok := w.(*idempotencyRecorder); ok {
+			rec.withheld = true
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func (app *application) idempotency(next http.Handler) http.Handler {
+	go func() {
+		for {
+			time.Sleep(time.Hour)
+
+			err := app.models.IdempotencyKeys.DeleteExpired(app.config.idempotency.ttl)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+
+		bank := app.contextGetBank(r)
+
+		if key == "" || bank.IsAnonymous() || readOnlyMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+
+		if data.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+
+		idempotencyKey := &data.IdempotencyKey{
+			BankId:        bank.Id,
+			Key:           key,
+			RequestMethod: r.Method,
+			RequestPath:   r.URL.Path,
+			RequestHash:   hash.Sum(nil),
+		}
+
+		claimed, err := app.models.IdempotencyKeys.Claim(idempotencyKey, app.config.idempotency.ttl)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !claimed {
+			existing, err := app.models.IdempotencyKeys.Get(bank.Id, key)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.idempotencyKeyInProgressResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			switch {
+			case !bytes.Equal(existing.RequestHash, idempotencyKey.RequestHash):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case !existing.Completed():
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				for key, value := range existing.ResponseHeaders {
+					w.Header()[key] = value
+				}
+
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.ResponseStatus)
+				w.Write(existing.ResponseBody)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		completed := false
+
+		// Release the key if the request fails or panics, so a retry runs it again.
+		defer func() {
+			if !completed {
+				err := app.models.IdempotencyKeys.Delete(bank.Id, key)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+
+		idempotencyKey.ResponseStatus, idempotencyKey.ResponseHeaders, idempotencyKey.ResponseBody = rec.stored()
+
+		err = app.models.IdempotencyKeys.Complete(idempotencyKey)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		completed = true
+	})
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
@@ -173,7 +480,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key")
 
 						w.WriteHeader(http.StatusOK)
 						return
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdempotencyRecorderStored(t *testing.T) {
+	app := &application{}
+
+	createCard := func(w http.ResponseWriter, r *http.Request) {
+		app.writeJSON(w, http.StatusCreated, envelope{"private_key": "card-private-key"}, nil)
+	}
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantKey    bool
+	}{
+		{"replayable", createCard, http.StatusCreated, true},
+		{"card create", app.withholdFromReplay(createCard), http.StatusConflict, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rec := &idempotencyRecorder{ResponseWriter: w}
+
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/cards", nil))
+
+			if !bytes.Contains(w.Body.Bytes(), []byte("card-private-key")) {
+				t.Fatalf("response body = %q, want the private key sent to the caller", w.Body.String())
+			}
+
+			status, _, body := rec.stored()
+
+			if status != tt.wantStatus {
+				t.Errorf("stored status = %d, want %d", status, tt.wantStatus)
+			}
+
+			if got := bytes.Contains(body, []byte("card-private-key")); got != tt.wantKey {
+				t.Errorf("stored body = %q, contains private key %t, want %t", body, got, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+func (app *application) createPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CardId          int64 `json:"card_id"`
+		TargetAccountId int64 `json:"target_account_id"`
+		AmountInCents   int64 `json:"amount_in_cents"`
+		Capture         *bool `json:"capture"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	payment := &data.Payment{
+		BankId:          requestingBank.Id,
+		CardId:          input.CardId,
+		TargetAccountId: input.TargetAccountId,
+		AmountInCents:   input.AmountInCents,
+		Capture:         true,
+	}
+
+	if input.Capture != nil {
+		payment.Capture = *input.Capture
+	}
+
+	v := validator.New()
+
+	if data.ValidatePayment(v, payment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Payments.New(payment, app.config.payments.challengeTTL, app.config.payments.frozenBankPolicy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrCardNotFound):
+			v.AddError("card_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCardExpired):
+			v.AddError("card_id", "card has expired")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCardInactive):
+			v.AddError("card_id", "card has been blocked or replaced")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCardLocked):
+			v.AddError("card_id", "card is locked after too many incorrect PIN attempts")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTargetAccountNotFound):
+			v.AddError("target_account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTargetBankFrozen):
+			v.AddError("target_account_id", "belongs to a frozen bank")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/payments/%d", payment.Id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"payment": payment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	payment, err := app.models.Payments.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"payment": payment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Signature []byte `json:"signature"`
+		Pin       string `json:"pin"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateSignature(v, input.Signature)
+
+	if data.ValidatePin(v, input.Pin); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	payment := &data.Payment{
+		Id:     id,
+		BankId: requestingBank.Id,
+	}
+
+	err = app.models.Payments.Confirm(payment, input.Signature, input.Pin, app.config.payments.maxPinAttempts, app.config.payments.authorizationTTL, app.config.payments.frozenBankPolicy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrPaymentNotPending):
+			app.paymentNotPendingResponse(w, r)
+		case errors.Is(err, data.ErrPaymentExpired):
+			v.AddError("payment", "challenge has expired, start a new payment")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInvalidSignature):
+			v.AddError("signature", "does not match the card's key")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrIncorrectPin):
+			v.AddError("pin", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCardNotFound):
+			v.AddError("card_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCardExpired):
+			v.AddError("card_id", "card has expired")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCardInactive):
+			v.AddError("card_id", "card has been blocked or replaced")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCardLocked):
+			v.AddError("card_id", "card is locked after too many incorrect PIN attempts")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTargetAccountNotFound):
+			v.AddError("target_account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountFrozen):
+			v.AddError("account", "source account must not be frozen")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountClosed):
+			v.AddError("account", "source and target accounts must not be closed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrBankFrozen):
+			v.AddError("card_id", "card's bank is frozen")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTargetBankFrozen):
+			v.AddError("target_account_id", "belongs to a frozen bank")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycTransactionLimitExceeded):
+			v.AddError("amount_in_cents", "must not exceed the source account's KYC transaction limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycBalanceLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the target account over its KYC balance limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrHoldingLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the target account over its holding limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientFunds):
+			v.AddError("amount_in_cents", "must not exceed the card account's available balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientReserves):
+			v.AddError("amount_in_cents", "must not exceed the reserve balance the card's bank settles payments to other banks from")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"payment": payment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
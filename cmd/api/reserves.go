@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+func (app *application) createIssuanceHandler(w http.ResponseWriter, r *http.Request) {
+	app.createReserveOperation(w, r, data.ReserveOperationIssuance)
+}
+
+func (app *application) createRedemptionHandler(w http.ResponseWriter, r *http.Request) {
+	app.createReserveOperation(w, r, data.ReserveOperationRedemption)
+}
+
+func (app *application) createReserveOperation(w http.ResponseWriter, r *http.Request, kind string) {
+	var input struct {
+		BankId        int64  `json:"bank_id"`
+		AmountInCents int64  `json:"amount_in_cents"`
+		Reason        string `json:"reason"`
+		Reference     string `json:"reference"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	// the operator is whoever signed the request in, never what the body claims
+	op := &data.ReserveOperation{
+		BankId:        input.BankId,
+		Kind:          kind,
+		AmountInCents: input.AmountInCents,
+		Operator:      requestingBank.Email,
+		Reason:        input.Reason,
+		Reference:     input.Reference,
+	}
+
+	if user := app.contextGetUser(r); user != nil {
+		op.Operator = user.Email
+		op.UserId = user.Id
+	}
+
+	v := validator.New()
+
+	if data.ValidateReserveOperation(v, op); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reserves.Insert(op)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("bank_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientReserves):
+			v.AddError("amount_in_cents", "must not exceed the bank's reserve balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{kind: op}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listReserveOperationsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		BankId int64
+		Kind   string
+		data.Filters
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.BankId = app.readInt64(qs, "bank_id", 0, v)
+	input.Kind = app.readString(qs, "kind", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "amount_in_cents", "created_at", "-id", "-amount_in_cents", "-created_at"}
+
+	// only supervisors may look at other banks' reserve operations
+	if !requestingBank.Can(data.PermissionSupervision) {
+		input.BankId = requestingBank.Id
+	}
+
+	v.Check(input.BankId >= 0, "bank_id", "must not be negative")
+	v.Check(input.Kind == "" || validator.PermittedValue(input.Kind, data.ReserveOperationIssuance, data.ReserveOperationRedemption), "kind", "must be issuance or redemption")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ops, metadata, err := app.models.Reserves.GetAll(input.BankId, input.Kind, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reserve_operations": ops, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"expvar"
 	"net/http"
 
+	"github.com/calmitchell617/reserva/internal/data"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -15,21 +16,94 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
-	// in prod, banks will be created by a backend UI
+	// in prod, banks are onboarded by the central bank through /v1/admin/banks
 	if app.config.env == "development" {
 		router.HandlerFunc(http.MethodPost, "/v1/banks", app.registerBankHandler)
 	}
 	router.HandlerFunc(http.MethodGet, "/v1/banks", app.requireActivatedBank(app.showBankHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/banks/entries", app.requireActivatedBank(app.listBankEntriesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/banks/source-ips", app.requireActivatedBank(app.updateBankSourceIpsHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/banks/activate", app.activateBankHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/banks/update-password", app.updateBankPasswordHandler)
 
-	router.HandlerFunc(http.MethodGet, "/v1/accounts", app.requireActivatedBank(app.listAccountsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/accounts", app.requireActivatedBank(app.createAccountHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/accounts/:id", app.requireActivatedBank(app.showAccountHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/accounts/:id", app.requireActivatedBank(app.updateAccountHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/accounts/:id", app.requireActivatedBank(app.deleteAccountHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users", app.requireUserAdmin(app.listUsersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.requireUserAdmin(app.createUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.requireUserAdmin(app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id", app.requireUserAdmin(app.updateUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/activate", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/update-password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/audit-events", app.requireUserAdmin(app.listAuditEventsHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/cards", app.requireActivatedBank(app.createCardHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/banks", app.requirePermission(data.PermissionSupervision, app.adminListBanksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/banks", app.requirePermission(data.PermissionSupervision, app.adminCreateBankHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/banks/:id", app.requirePermission(data.PermissionSupervision, app.adminShowBankHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/banks/:id", app.requirePermission(data.PermissionSupervision, app.adminUpdateBankHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/banks/:id/activate", app.requirePermission(data.PermissionSupervision, app.adminActivateBankHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/banks/:id/freeze", app.requirePermission(data.PermissionSupervision, app.adminFreezeBankHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/banks/:id/unfreeze", app.requirePermission(data.PermissionSupervision, app.adminUnfreezeBankHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/banks/:id/freeze-events", app.requirePermission(data.PermissionSupervision, app.adminListBankFreezeEventsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/banks/:id/bins", app.requirePermission(data.PermissionSupervision, app.adminListBankBinsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/banks/:id/bins", app.requirePermission(data.PermissionSupervision, app.adminCreateBankBinHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/issuances", app.requirePermission(data.PermissionMonetary, app.createIssuanceHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/redemptions", app.requirePermission(data.PermissionMonetary, app.createRedemptionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/reserve-operations", app.requireActivatedBank(app.listReserveOperationsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/accounts", app.requirePermission(data.PermissionCustomers, app.listAccountsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts", app.requirePermission(data.PermissionCustomers, app.createAccountHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/:id", app.requirePermission(data.PermissionCustomers, app.showAccountHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/accounts/:id", app.requirePermission(data.PermissionCustomers, app.updateAccountHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/accounts/:id/depositors", app.requirePermission(data.PermissionCustomers, app.updateAccountDepositorsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/:id/entries", app.requirePermission(data.PermissionCustomers, app.listAccountEntriesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/:id/holds", app.requirePermission(data.PermissionCustomers, app.listHoldsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/:id/holds", app.requirePermission(data.PermissionCustomers, app.createHoldHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/:id/holds/:hold_id", app.requirePermission(data.PermissionCustomers, app.showHoldHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/:id/holds/:hold_id/release", app.requirePermission(data.PermissionCustomers, app.releaseHoldHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/:id/close", app.requirePermission(data.PermissionCustomers, app.closeAccountHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/:id/cash-in", app.requirePermission(data.PermissionCustomers, app.cashInHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/:id/cash-out", app.requirePermission(data.PermissionCustomers, app.cashOutHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/kyc-tiers", app.requireActivatedBank(app.listKycTiersHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/kyc-tiers/:tier", app.requirePermission(data.PermissionMonetary, app.updateKycTierHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/depositors", app.requirePermission(data.PermissionCustomers, app.listDepositorsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/depositors", app.requirePermission(data.PermissionCustomers, app.createDepositorHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/depositors/:id", app.requirePermission(data.PermissionCustomers, app.showDepositorHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/depositors/:id", app.requirePermission(data.PermissionCustomers, app.updateDepositorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/depositors/:id", app.requirePermission(data.PermissionCustomers, app.deleteDepositorHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/bins", app.requireActivatedBank(app.listBinsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/bins/:number", app.requireActivatedBank(app.showBinHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/cards", app.requirePermission(data.PermissionCustomers, app.listCardsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/cards", app.requirePermission(data.PermissionCustomers, app.withholdFromReplay(app.createCardHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/card-batches", app.requirePermission(data.PermissionCustomers, app.withholdFromReplay(app.createCardBatchHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/cards/:id", app.requirePermission(data.PermissionCustomers, app.showCardHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/cards/:id/pin", app.requirePermission(data.PermissionCustomers, app.updateCardPinHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/cards/:id/block", app.requirePermission(data.PermissionCustomers, app.blockCardHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/cards/:id/unlock", app.requirePermission(data.PermissionCustomers, app.unlockCardHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/cards/:id/replace", app.requirePermission(data.PermissionCustomers, app.withholdFromReplay(app.replaceCardHandler)))
+
+	router.HandlerFunc(http.MethodPost, "/v1/payments", app.requirePermission(data.PermissionPayments, app.createPaymentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/payments/:id", app.requirePermission(data.PermissionPayments, app.showPaymentHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/payments/:id/confirm", app.requirePermission(data.PermissionPayments, app.confirmPaymentHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/authorizations", app.requirePermission(data.PermissionPayments, app.listAuthorizationsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/authorizations/:id", app.requirePermission(data.PermissionPayments, app.showAuthorizationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/authorizations/:id/capture", app.requirePermission(data.PermissionPayments, app.captureAuthorizationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/authorizations/:id/void", app.requirePermission(data.PermissionPayments, app.voidAuthorizationHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/transfers", app.requirePermission(data.PermissionCustomers, app.listTransfersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/transfers", app.requirePermission(data.PermissionCustomers, app.createTransferHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/transfers/:id", app.requirePermission(data.PermissionCustomers, app.showTransferHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/transfers/:id/refunds", app.requirePermission(data.PermissionCustomers, app.listRefundsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/transfers/:id/refunds", app.requirePermission(data.PermissionCustomers, app.createRefundHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/scheduled-transfers", app.requirePermission(data.PermissionCustomers, app.listScheduledTransfersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/scheduled-transfers", app.requirePermission(data.PermissionCustomers, app.createScheduledTransferHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/scheduled-transfers/:id", app.requirePermission(data.PermissionCustomers, app.showScheduledTransferHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/scheduled-transfers/:id/runs", app.requirePermission(data.PermissionCustomers, app.listScheduledTransferRunsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/scheduled-transfers/:id/cancel", app.requirePermission(data.PermissionCustomers, app.cancelScheduledTransferHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
@@ -37,5 +111,5 @@ func (app *application) routes() http.Handler {
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.audit(app.idempotency(router)))))))
 }
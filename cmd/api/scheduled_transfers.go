@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+func (app *application) createScheduledTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		SourceAccountId int64      `json:"source_account_id"`
+		TargetAccountId int64      `json:"target_account_id"`
+		AmountInCents   int64      `json:"amount_in_cents"`
+		Description     string     `json:"description"`
+		Frequency       string     `json:"frequency"`
+		Every           int        `json:"every"`
+		StartsAt        *time.Time `json:"starts_at"`
+		EndsAt          *time.Time `json:"ends_at"`
+		MaxOccurrences  int        `json:"max_occurrences"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	st := &data.ScheduledTransfer{
+		BankId:          requestingBank.Id,
+		SourceAccountId: input.SourceAccountId,
+		TargetAccountId: input.TargetAccountId,
+		AmountInCents:   input.AmountInCents,
+		Description:     input.Description,
+		Frequency:       input.Frequency,
+		Every:           input.Every,
+		StartsAt:        time.Now().Truncate(time.Second),
+		EndsAt:          input.EndsAt,
+		MaxOccurrences:  input.MaxOccurrences,
+	}
+
+	if input.StartsAt != nil {
+		st.StartsAt = *input.StartsAt
+	}
+
+	if st.Every == 0 {
+		st.Every = 1
+	}
+
+	v := validator.New()
+
+	if data.ValidateScheduledTransfer(v, st); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ScheduledTransfers.Insert(st)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSourceAccountNotFound):
+			v.AddError("source_account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTargetAccountNotFound):
+			v.AddError("target_account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/scheduled-transfers/%d", st.Id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"scheduled_transfer": st}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showScheduledTransferHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	st, err := app.models.ScheduledTransfers.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"scheduled_transfer": st}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) cancelScheduledTransferHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	st, err := app.models.ScheduledTransfers.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.ScheduledTransfers.Cancel(st)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrScheduledTransferNotActive):
+			app.scheduledTransferNotActiveResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"scheduled_transfer": st}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listScheduledTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status    string
+		AccountId int64
+		data.Filters
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.AccountId = app.readInt64(qs, "account_id", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "next_run_at")
+	input.Filters.SortSafelist = []string{"id", "amount_in_cents", "next_run_at", "created_at", "-id", "-amount_in_cents", "-next_run_at", "-created_at"}
+
+	v.Check(input.Status == "" || validator.PermittedValue(input.Status, data.ScheduledTransferStatusActive, data.ScheduledTransferStatusCompleted, data.ScheduledTransferStatusCancelled), "status", "must be active, completed or cancelled")
+	v.Check(input.AccountId >= 0, "account_id", "must not be negative")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scheduled, metadata, err := app.models.ScheduledTransfers.GetAll(requestingBank.Id, input.Status, input.AccountId, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"scheduled_transfers": scheduled, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listScheduledTransferRunsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "occurrence", "created_at", "-id", "-occurrence", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	st, err := app.models.ScheduledTransfers.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	runs, metadata, err := app.models.ScheduledTransfers.GetRuns(st.Id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"runs": runs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/calmitchell617/reserva/internal/data"
+)
+
+// runScheduler runs due scheduled transfers every scheduler interval until ctx
+// is cancelled.
+func (app *application) runScheduler(ctx context.Context) {
+	ticker := time.NewTicker(app.config.scheduler.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.runDueTransfers(ctx)
+		}
+	}
+}
+
+func (app *application) runDueTransfers(ctx context.Context) {
+	for ctx.Err() == nil {
+		st, run, err := app.models.ScheduledTransfers.RunNext(app.config.scheduler.maxRetries, app.config.scheduler.retryDelay, app.config.payments.frozenBankPolicy)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		if st == nil {
+			return
+		}
+
+		if run.Status == data.ScheduledRunStatusFailed {
+			app.notifyScheduledTransferFailed(st, run)
+		}
+	}
+}
+
+func (app *application) notifyScheduledTransferFailed(st *data.ScheduledTransfer, run *data.ScheduledTransferRun) {
+	bank, err := app.models.Banks.Get(st.BankId)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	data := map[string]interface{}{
+		"scheduledTransferID": st.Id,
+		"sourceAccountID":     st.SourceAccountId,
+		"targetAccountID":     st.TargetAccountId,
+		"amountInCents":       st.AmountInCents,
+		"scheduledFor":        run.ScheduledFor.Format(time.RFC3339),
+		"attempts":            run.Attempt,
+		"error":               run.Error,
+	}
+
+	err = app.mailer.Send(bank.Email, "scheduled_transfer_failed.tmpl", data)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
@@ -22,6 +22,12 @@ func (app *application) serve() error {
 
 	shutdownError := make(chan error)
 
+	ctx, stopScheduler := context.WithCancel(context.Background())
+
+	app.background(func() {
+		app.runScheduler(ctx)
+	})
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -39,6 +45,8 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		stopScheduler()
+
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
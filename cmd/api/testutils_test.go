@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/jsonlog"
+	"github.com/calmitchell617/reserva/internal/mailer"
+)
+
+// newTestApplication returns an application backed by a freshly migrated
+// schema in the database named by RESERVA_TEST_DB_DSN, dropped when the test
+// ends. Mail goes nowhere. Tests that need it are skipped when the variable
+// isn't set.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	dsn := os.Getenv("RESERVA_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("RESERVA_TEST_DB_DSN is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+
+	_, err = admin.Exec(`CREATE EXTENSION IF NOT EXISTS citext WITH SCHEMA public`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		admin, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer admin.Close()
+
+		_, err = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	searchPath := schema + ",public"
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "search_path=" + url.QueryEscape(searchPath)
+	} else {
+		dsn += " search_path=" + searchPath
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+
+	for _, migration := range migrations {
+		statements, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec(string(statements))
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(migration), err)
+		}
+	}
+
+	app := &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelOff),
+		models: data.NewModels(db, db),
+		mailer: mailer.New("localhost", 1, "", "", "test@example.com", "development"),
+	}
+
+	// wait for background mail before the schema is dropped
+	t.Cleanup(app.wg.Wait)
+
+	return app
+}
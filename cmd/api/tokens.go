@@ -7,6 +7,7 @@ import (
 
 	"github.com/calmitchell617/reserva/internal/data"
 	"github.com/calmitchell617/reserva/internal/validator"
+	"github.com/tomasen/realip"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -31,6 +32,16 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	user, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		app.createUserAuthenticationToken(w, r, user, input.Password)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	bank, err := app.models.Banks.GetByEmail(input.Email)
 	if err != nil {
 		switch {
@@ -53,6 +64,11 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	if !bank.AllowsIP(realip.FromRequest(r)) {
+		app.sourceIPNotAllowedResponse(w, r)
+		return
+	}
+
 	token, err := app.models.Tokens.New(bank.Id, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -83,6 +99,16 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
+	user, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		app.createUserPasswordResetToken(w, r, user)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	bank, err := app.models.Banks.GetByEmail(input.Email)
 	if err != nil {
 		switch {
@@ -144,6 +170,16 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
+	user, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		app.createUserActivationToken(w, r, user)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	bank, err := app.models.Banks.GetByEmail(input.Email)
 	if err != nil {
 		switch {
@@ -186,3 +222,99 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) createUserAuthenticationToken(w http.ResponseWriter, r *http.Request, user *data.User, password string) {
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match || user.Disabled {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	bank, err := app.models.Banks.Get(user.BankId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !bank.AllowsIP(realip.FromRequest(r)) {
+		app.sourceIPNotAllowedResponse(w, r)
+		return
+	}
+
+	token, err := app.models.Tokens.NewForUser(user, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createUserPasswordResetToken(w http.ResponseWriter, r *http.Request, user *data.User) {
+	v := validator.New()
+
+	v.Check(user.Activated, "email", "user must be activated")
+	v.Check(!user.Disabled, "email", "user has been disabled")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.NewForUser(user, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "user_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createUserActivationToken(w http.ResponseWriter, r *http.Request, user *data.User) {
+	v := validator.New()
+
+	v.Check(!user.Activated, "email", "user has already been activated")
+	v.Check(!user.Disabled, "email", "user has been disabled")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.sendUserActivation(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
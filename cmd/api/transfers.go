@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/calmitchell617/reserva/internal/data"
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+func (app *application) createTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		SourceAccountId int64 `json:"source_account_id"`
+		TargetAccountId int64 `json:"target_account_id"`
+		AmountInCents   int64 `json:"amount_in_cents"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	transfer := &data.Transfer{
+		SourceAccountId: input.SourceAccountId,
+		TargetAccountId: input.TargetAccountId,
+		AmountInCents:   input.AmountInCents,
+		UserId:          app.contextGetUserId(r),
+	}
+
+	v := validator.New()
+
+	if data.ValidateTransfer(v, transfer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	err = app.models.Transfers.Insert(transfer, requestingBank.Id, app.config.payments.frozenBankPolicy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSourceAccountNotFound):
+			v.AddError("source_account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTargetAccountNotFound):
+			v.AddError("target_account_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountFrozen):
+			v.AddError("account", "source account must not be frozen")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountClosed):
+			v.AddError("account", "source and target accounts must not be closed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrBankFrozen):
+			app.bankFrozenResponse(w, r)
+		case errors.Is(err, data.ErrTargetBankFrozen):
+			v.AddError("target_account_id", "belongs to a frozen bank")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycTransactionLimitExceeded):
+			v.AddError("amount_in_cents", "must not exceed the source account's KYC transaction limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycBalanceLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the target account over its KYC balance limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrHoldingLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the target account over its holding limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientFunds):
+			v.AddError("amount_in_cents", "must not exceed the source account's available balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientReserves):
+			v.AddError("amount_in_cents", "must not exceed the reserve balance the bank settles transfers to other banks from")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/transfers/%d", transfer.Id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"transfer": transfer}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showTransferHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	transfer, err := app.models.Transfers.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"transfer": transfer}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.TransferQuery
+		data.Filters
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.TransferQuery.AccountId = app.readInt64(qs, "account_id", 0, v)
+	input.TransferQuery.SourceAccountId = app.readInt64(qs, "source_account_id", 0, v)
+	input.TransferQuery.TargetAccountId = app.readInt64(qs, "target_account_id", 0, v)
+	input.TransferQuery.RefundOfTransferId = app.readInt64(qs, "refund_of_transfer_id", 0, v)
+	input.TransferQuery.Direction = app.readString(qs, "direction", data.DirectionAll)
+	input.TransferQuery.MinAmountInCents = app.readInt64(qs, "min_amount_in_cents", 0, v)
+	input.TransferQuery.MaxAmountInCents = app.readInt64(qs, "max_amount_in_cents", 0, v)
+	input.TransferQuery.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.TransferQuery.CreatedBefore = app.readTime(qs, "created_before", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "amount_in_cents", "created_at", "-id", "-amount_in_cents", "-created_at"}
+
+	data.ValidateTransferQuery(v, input.TransferQuery)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transfers, metadata, err := app.models.Transfers.GetAll(requestingBank.Id, input.TransferQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"transfers": transfers, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRefundHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		AmountInCents int64  `json:"amount_in_cents"`
+		Reason        string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	refund := &data.Transfer{
+		AmountInCents:      input.AmountInCents,
+		RefundOfTransferId: id,
+		RefundReason:       input.Reason,
+		UserId:             app.contextGetUserId(r),
+	}
+
+	v := validator.New()
+
+	if data.ValidateRefund(v, refund); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	err = app.models.Transfers.Refund(refund, requestingBank.Id, app.config.payments.frozenBankPolicy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrRefundNotPermitted):
+			app.notPermittedResponse(w, r)
+		case errors.Is(err, data.ErrTransferRefunded):
+			app.transferRefundedResponse(w, r)
+		case errors.Is(err, data.ErrRefundOfRefund):
+			v.AddError("transfer", "refunds can't themselves be refunded")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRefundExceedsTransfer):
+			v.AddError("amount_in_cents", "must not exceed the amount left to refund")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountFrozen):
+			v.AddError("account", "refunding account must not be frozen")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAccountClosed):
+			v.AddError("account", "source and target accounts must not be closed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrBankFrozen):
+			app.bankFrozenResponse(w, r)
+		case errors.Is(err, data.ErrTargetBankFrozen):
+			v.AddError("transfer", "the original source account belongs to a frozen bank")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycTransactionLimitExceeded):
+			v.AddError("amount_in_cents", "must not exceed the refunding account's KYC transaction limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrKycBalanceLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the refunded account over its KYC balance limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrHoldingLimitExceeded):
+			v.AddError("amount_in_cents", "must not take the refunded account over its holding limit")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientFunds):
+			v.AddError("amount_in_cents", "must not exceed the refunding account's available balance")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInsufficientReserves):
+			v.AddError("amount_in_cents", "must not exceed the reserve balance the refunding bank settles transfers to other banks from")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/transfers/%d", refund.Id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"refund": refund}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRefundsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	requestingBank := app.contextGetBank(r)
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "amount_in_cents", "created_at", "-id", "-amount_in_cents", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transfer, err := app.models.Transfers.Get(id, requestingBank.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	q := data.TransferQuery{
+		Direction:          data.DirectionAll,
+		RefundOfTransferId: transfer.Id,
+	}
+
+	refunds, metadata, err := app.models.Transfers.GetAll(requestingBank.Id, q, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"transfer": transfer, "refunds": refunds, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
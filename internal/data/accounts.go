@@ -8,19 +8,65 @@ import (
 	"time"
 
 	"github.com/calmitchell617/reserva/internal/validator"
+	"github.com/lib/pq"
 )
 
+var (
+	ErrOverflowAccountNotFound = errors.New("overflow account not found")
+	ErrHoldingLimitExceeded    = errors.New("holding limit exceeded")
+	ErrAccountClosed           = errors.New("account closed")
+	ErrNonZeroBalance          = errors.New("non-zero balance")
+)
+
+const (
+	AccountStatusActive = "active"
+	AccountStatusFrozen = "frozen"
+	AccountStatusClosed = "closed"
+)
+
+// An Account with a holding limit can't be credited beyond it. Transfers that
+// would push it over the limit send the excess on to its overflow account, if
+// it has one, and are rejected otherwise. A limit of 0 means unlimited.
 type Account struct {
-	Id             int64 `json:"id"`
-	BankId         int64 `json:"bank_id"`
-	BalanceInCents int64 `json:"balance_in_cents"`
-	Frozen         bool  `json:"frozen"`
-	Version        int64 `json:"version"`
+	Id                  int64      `json:"id"`
+	BankId              int64      `json:"bank_id"`
+	BalanceInCents      int64      `json:"balance_in_cents"`
+	AvailableInCents    int64      `json:"available_balance_in_cents"`
+	HoldingLimitInCents int64      `json:"holding_limit_in_cents"`
+	OverflowAccountId   int64      `json:"overflow_account_id,omitempty"`
+	Status              string     `json:"status"`
+	Depositors          []int64    `json:"depositors"`
+	KycTier             string     `json:"kyc_tier"`
+	ClosedAt            *time.Time `json:"closed_at,omitempty"`
+	Version             int64      `json:"version"`
 }
 
+// usable reports why money can't move in or out of the account, if it can't.
+func (a *Account) usable() error {
+	switch a.Status {
+	case AccountStatusFrozen:
+		return ErrAccountFrozen
+	case AccountStatusClosed:
+		return ErrAccountClosed
+	}
+
+	return nil
+}
+
+// accountDepositorsColumn lists the depositors attached to an account. It
+// expects the accounts table to be in scope.
+const accountDepositorsColumn = `
+        COALESCE((SELECT array_agg(depositor_id ORDER BY depositor_id) FROM account_depositors WHERE account_id = accounts.id), '{}')`
+
 func ValidateAccount(v *validator.Validator, account *Account) {
 	v.Check(account.BankId != 0, "bank_id", "must be provided")
 	v.Check(account.BankId > 0, "bank_id", "must be greater than 0")
+
+	v.Check(account.HoldingLimitInCents >= 0, "holding_limit_in_cents", "must not be negative")
+	v.Check(account.OverflowAccountId >= 0, "overflow_account_id", "must not be negative")
+	v.Check(account.OverflowAccountId == 0 || account.OverflowAccountId != account.Id, "overflow_account_id", "must be different from the account's id")
+
+	ValidateDepositorIds(v, account.Depositors)
 }
 
 type AccountModel struct {
@@ -29,17 +75,89 @@ type AccountModel struct {
 }
 
 func (m AccountModel) Insert(account *Account) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
         INSERT INTO accounts (bank_id) 
         VALUES ($1)
-        RETURNING id, version`
+        RETURNING id, status, version`
 
 	args := []interface{}{account.BankId}
 
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&account.Id, &account.Status, &account.Version)
+	if err != nil {
+		return err
+	}
+
+	if account.Depositors == nil {
+		account.Depositors = []int64{}
+	}
+
+	err = setAccountDepositors(ctx, tx, account.Id, account.Depositors, account.BankId)
+	if err != nil {
+		return err
+	}
+
+	account.KycTier, err = accountKycTier(ctx, tx, account.Id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetDepositors replaces the depositors attached to account with depositorIds.
+func (m AccountModel) SetDepositors(account *Account, depositorIds []int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&account.Id, &account.Version)
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE accounts
+        SET version = version + 1
+        WHERE id = $1 AND bank_id = $2 AND version = $3
+        RETURNING balance_in_cents, version`
+
+	err = tx.QueryRowContext(ctx, query, account.Id, account.BankId, account.Version).Scan(&account.BalanceInCents, &account.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = setAccountDepositors(ctx, tx, account.Id, depositorIds, account.BankId)
+	if err != nil {
+		return err
+	}
+
+	account.KycTier, err = accountKycTier(ctx, tx, account.Id)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	account.Depositors = depositorIds
+
+	return nil
 }
 
 func (m AccountModel) Get(id int64, bankId int64) (*Account, error) {
@@ -47,10 +165,10 @@ func (m AccountModel) Get(id int64, bankId int64) (*Account, error) {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `
-        SELECT id, bank_id, balance_in_cents, frozen, version
+	query := fmt.Sprintf(`
+        SELECT id, bank_id, balance_in_cents, balance_in_cents - %s, holding_limit_in_cents, COALESCE(overflow_account_id, 0), %s, %s, %s, closed_at, version
         FROM accounts
-        WHERE id = $1 and bank_id = $2`
+        WHERE id = $1 and bank_id = $2`, accountHeldColumn, accountStatusColumn, accountDepositorsColumn, accountKycTierColumn)
 
 	var account Account
 
@@ -61,7 +179,13 @@ func (m AccountModel) Get(id int64, bankId int64) (*Account, error) {
 		&account.Id,
 		&account.BankId,
 		&account.BalanceInCents,
-		&account.Frozen,
+		&account.AvailableInCents,
+		&account.HoldingLimitInCents,
+		&account.OverflowAccountId,
+		&account.Status,
+		pq.Array(&account.Depositors),
+		&account.KycTier,
+		&account.ClosedAt,
 		&account.Version,
 	)
 
@@ -80,13 +204,13 @@ func (m AccountModel) Get(id int64, bankId int64) (*Account, error) {
 func (m AccountModel) Update(account *Account, bankId int64) error {
 	query := `
         UPDATE accounts 
-        SET balance_in_cents = $1, frozen = $2, version = version + 1
-        WHERE id = $3 and bank_id = $4 and version = $5
-        RETURNING version`
+        SET holding_limit_in_cents = $1, overflow_account_id = NULLIF($2::bigint, 0), version = version + 1
+        WHERE id = $3 and bank_id = $4 and version = $5 and status <> 'closed'
+        RETURNING balance_in_cents, version`
 
 	args := []interface{}{
-		account.BalanceInCents,
-		account.Frozen,
+		account.HoldingLimitInCents,
+		account.OverflowAccountId,
 		account.Id,
 		account.BankId,
 		account.Version,
@@ -95,9 +219,11 @@ func (m AccountModel) Update(account *Account, bankId int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&account.Version)
+	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&account.BalanceInCents, &account.Version)
 	if err != nil {
 		switch {
+		case err.Error() == `pq: insert or update on table "accounts" violates foreign key constraint "accounts_overflow_account_id_fkey"`:
+			return ErrOverflowAccountNotFound
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
@@ -108,47 +234,262 @@ func (m AccountModel) Update(account *Account, bankId int64) error {
 	return nil
 }
 
-func (m AccountModel) Delete(id int64, bankId int64) error {
-	if id < 1 {
-		return ErrRecordNotFound
+// Close closes account for good. It must not have any holds in force or be on
+// either side of a pending authorization, and its balance must be zero, unless
+// sweepAccountId is given, in which case the balance is first transferred
+// there. Active cards on the account are blocked and active scheduled
+// transfers to or from it are cancelled. Closed accounts stay readable so
+// their history is kept.
+func (m AccountModel) Close(account *Account, sweepAccountId int64, frozenBankPayments string) (*Transfer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
+	// the sweep may settle with another bank, whose reserve has to be locked
+	// before any account, as every transfer between banks does
+	err = lockTransferBanks(ctx, tx, &Transfer{SourceAccountId: account.Id, TargetAccountId: sweepAccountId})
+	if err != nil {
+		return nil, err
+	}
+
+	// lock the sweep account along with the account, in id order, so the sweep
+	// transfer can't deadlock with a transfer going the other way
 	query := `
-        DELETE FROM accounts
-        WHERE id = $1 and bank_id = $2`
+        SELECT id
+        FROM accounts
+        WHERE id = $1 OR id = $2
+        ORDER BY id
+        FOR UPDATE`
 
+	_, err = tx.ExecContext(ctx, query, account.Id, sweepAccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+        SELECT balance_in_cents, status
+        FROM accounts
+        WHERE id = $1 AND bank_id = $2`
+
+	err = tx.QueryRowContext(ctx, query, account.Id, account.BankId).Scan(&account.BalanceInCents, &account.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if account.Status == AccountStatusClosed {
+		return nil, ErrAccountClosed
+	}
+
+	var held bool
+
+	query = fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM holds WHERE holds.account_id = $1 AND %s)`, activeHold)
+
+	err = tx.QueryRowContext(ctx, query, account.Id).Scan(&held)
+	if err != nil {
+		return nil, err
+	}
+
+	if held {
+		return nil, ErrActiveHolds
+	}
+
+	var authorized bool
+
+	query = fmt.Sprintf(`
+        SELECT EXISTS (
+            SELECT 1 FROM authorizations
+            WHERE (authorizations.account_id = $1 OR authorizations.target_account_id = $1) AND %s
+        )`, pendingAuthorization)
+
+	err = tx.QueryRowContext(ctx, query, account.Id).Scan(&authorized)
+	if err != nil {
+		return nil, err
+	}
+
+	if authorized {
+		return nil, ErrPendingAuthorizations
+	}
+
+	var sweep *Transfer
+
+	if account.BalanceInCents != 0 {
+		if sweepAccountId == 0 {
+			return nil, ErrNonZeroBalance
+		}
+
+		sweep = &Transfer{
+			SourceAccountId: account.Id,
+			TargetAccountId: sweepAccountId,
+			AmountInCents:   account.BalanceInCents,
+		}
+
+		err = insertTransfer(ctx, tx, sweep, account.BankId, frozenBankPayments)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query = `
+        UPDATE accounts
+        SET status = $1, closed_at = now(), version = version + 1
+        WHERE id = $2
+        RETURNING balance_in_cents, closed_at, version`
+
+	err = tx.QueryRowContext(ctx, query, AccountStatusClosed, account.Id).Scan(&account.BalanceInCents, &account.ClosedAt, &account.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+        UPDATE cards
+        SET status = $1, blocked_reason = $2, version = version + 1
+        WHERE account_id = $3 AND status = $4`
+
+	_, err = tx.ExecContext(ctx, query, CardStatusBlocked, "account closed", account.Id, CardStatusActive)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+        UPDATE scheduled_transfers
+        SET status = $1, next_run_at = NULL, version = version + 1
+        WHERE (source_account_id = $2 OR target_account_id = $2) AND status = $3`
+
+	_, err = tx.ExecContext(ctx, query, ScheduledTransferStatusCancelled, account.Id, ScheduledTransferStatusActive)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	account.Status = AccountStatusClosed
+
+	return sweep, nil
+}
+
+// CashIn moves amountInCents from the bank's reserve balance into account.
+func (m AccountModel) CashIn(account *Account, amountInCents int64) (*Posting, error) {
+	return m.cash(account, amountInCents, PostingKindCashIn)
+}
+
+// CashOut moves amountInCents from account back into the bank's reserve balance.
+func (m AccountModel) CashOut(account *Account, amountInCents int64) (*Posting, error) {
+	return m.cash(account, amountInCents, PostingKindCashOut)
+}
+
+// cash locks the bank before the account, so it can't deadlock with transfers
+// between banks, which lock banks before accounts too, or reserve operations,
+// which only lock banks.
+func (m AccountModel) cash(account *Account, amountInCents int64, kind string) (*Posting, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.WriteDb.ExecContext(ctx, query, id, bankId)
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	var reserveInCents int64
+
+	query := `
+        SELECT balance_in_cents
+        FROM banks
+        WHERE id = $1
+        FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, account.BankId).Scan(&reserveInCents)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	query = `
+        SELECT balance_in_cents, status, version
+        FROM accounts
+        WHERE id = $1 AND bank_id = $2
+        FOR UPDATE`
+
+	// only the stored status is read here, freezes are enforced on debits by post
+	locked := &Account{}
+
+	err = tx.QueryRowContext(ctx, query, account.Id, account.BankId).Scan(&locked.BalanceInCents, &locked.Status, &locked.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	err = locked.usable()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	switch {
+	case kind == PostingKindCashIn && reserveInCents < amountInCents:
+		return nil, ErrInsufficientReserves
+	case kind == PostingKindCashOut && locked.BalanceInCents < amountInCents:
+		return nil, ErrInsufficientFunds
+	}
+
+	amount := amountInCents
+	if kind == PostingKindCashOut {
+		amount = -amount
+	}
+
+	posting := &Posting{
+		Kind: kind,
+		Entries: []*LedgerEntry{
+			{BankId: account.BankId, AmountInCents: -amount},
+			{AccountId: account.Id, AmountInCents: amount},
+		},
+	}
+
+	err = post(ctx, tx, posting)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	account.BalanceInCents = locked.BalanceInCents + amount
+	account.AvailableInCents += account.BalanceInCents - locked.BalanceInCents
+	account.Version = locked.Version + 1
+
+	return posting, nil
 }
 
-func (m AccountModel) GetAll(bankId int64, filters Filters) ([]*Account, Metadata, error) {
+func (m AccountModel) GetAll(bankId int64, status string, filters Filters) ([]*Account, Metadata, error) {
 	query := fmt.Sprintf(`
-        SELECT count(*) OVER(), id, bank_id, balance_in_cents, frozen, version
+        SELECT count(*) OVER(), id, bank_id, balance_in_cents, balance_in_cents - %s, holding_limit_in_cents, COALESCE(overflow_account_id, 0), %s, %s, %s, closed_at, version
         FROM accounts
         where bank_id = $1
+        AND ($2::text = '' OR %s = $2)
         ORDER BY %s %s, id ASC
-        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+        LIMIT $3 OFFSET $4`, accountHeldColumn, accountStatusColumn, accountDepositorsColumn, accountKycTierColumn, accountStatusColumn, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{bankId, filters.limit(), filters.offset()}
+	args := []interface{}{bankId, status, filters.limit(), filters.offset()}
 
 	rows, err := m.ReadDb.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -168,7 +509,13 @@ func (m AccountModel) GetAll(bankId int64, filters Filters) ([]*Account, Metadat
 			&account.Id,
 			&account.BankId,
 			&account.BalanceInCents,
-			&account.Frozen,
+			&account.AvailableInCents,
+			&account.HoldingLimitInCents,
+			&account.OverflowAccountId,
+			&account.Status,
+			pq.Array(&account.Depositors),
+			&account.KycTier,
+			&account.ClosedAt,
 			&account.Version,
 		)
 		if err != nil {
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCloseAccountWithPendingAuthorization(t *testing.T) {
+	models := newTestModels(t)
+
+	insertTestBank(t, models, "central", RoleCentralBank)
+	bank := insertTestBank(t, models, "issuer", RoleCommercialBank)
+
+	cardAccount := insertTestAccount(t, models, bank)
+	merchant := insertTestAccount(t, models, bank)
+	sweep := insertTestAccount(t, models, bank)
+
+	issueTestReserves(t, models, bank, 100)
+
+	_, err := models.Accounts.CashIn(cardAccount, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	card := &Card{
+		Id:        2000000000000001,
+		AccountId: cardAccount.Id,
+		PublicKey: make(ed25519.PublicKey, ed25519.PublicKeySize),
+		Expiry:    time.Now().AddDate(1, 0, 0),
+	}
+
+	err = card.Password.Set("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Cards.Insert(card, bank.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorization := &Authorization{
+		BankId:          bank.Id,
+		CardId:          card.Id,
+		AccountId:       cardAccount.Id,
+		TargetAccountId: merchant.Id,
+		AmountInCents:   50,
+		ExpiresAt:       time.Now().Add(time.Hour),
+	}
+
+	tx, err := models.Authorizations.WriteDb.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	err = insertAuthorization(context.Background(), tx, authorization)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the card account still has a balance to sweep and the merchant has none,
+	// so neither close is refused for any other reason
+	tests := []struct {
+		name           string
+		account        *Account
+		sweepAccountId int64
+	}{
+		{"card account", cardAccount, sweep.Id},
+		{"target account", merchant, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := models.Accounts.Close(tt.account, tt.sweepAccountId, FrozenBankPaymentsAccept)
+			if !errors.Is(err, ErrPendingAuthorizations) {
+				t.Fatalf("Accounts.Close() error = %v, want %v", err, ErrPendingAuthorizations)
+			}
+		})
+	}
+
+	err = models.Authorizations.Void(authorization)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		_, err := models.Accounts.Close(tt.account, tt.sweepAccountId, FrozenBankPaymentsAccept)
+		if err != nil {
+			t.Errorf("Accounts.Close(%s) after void error = %v", tt.name, err)
+		}
+	}
+}
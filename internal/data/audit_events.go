@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// An AuditEvent records a write request made on behalf of a bank, and by which
+// of its users. UserId is 0 for requests made with the bank's own credentials.
+type AuditEvent struct {
+	Id        int64     `json:"id"`
+	BankId    int64     `json:"-"`
+	UserId    int64     `json:"user_id,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AuditEventModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+func (m AuditEventModel) Insert(event *AuditEvent) error {
+	query := `
+        INSERT INTO audit_events (bank_id, user_id, method, path, status)
+        VALUES ($1, NULLIF($2::bigint, 0), $3, $4, $5)
+        RETURNING id, created_at`
+
+	args := []interface{}{event.BankId, event.UserId, event.Method, event.Path, event.Status}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&event.Id, &event.CreatedAt)
+}
+
+// GetAll lists bankId's audit events, only those of userId unless it is 0.
+func (m AuditEventModel) GetAll(bankId int64, userId int64, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, bank_id, COALESCE(user_id, 0), method, path, status, created_at
+        FROM audit_events
+        WHERE bank_id = $1
+        AND ($2::bigint = 0 OR user_id = $2)
+        ORDER BY %s %s, id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, bankId, userId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.Id,
+			&event.BankId,
+			&event.UserId,
+			&event.Method,
+			&event.Path,
+			&event.Status,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
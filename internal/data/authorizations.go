@@ -0,0 +1,369 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAuthorizationNotPending = errors.New("authorization not pending")
+	ErrAuthorizationExpired    = errors.New("authorization expired")
+	ErrCaptureExceedsAmount    = errors.New("capture exceeds authorized amount")
+	ErrPendingAuthorizations   = errors.New("pending authorizations")
+)
+
+const (
+	AuthorizationStatusPending  = "pending"
+	AuthorizationStatusCaptured = "captured"
+	AuthorizationStatusVoided   = "voided"
+	AuthorizationStatusExpired  = "expired"
+)
+
+// An Authorization reserves AmountInCents on a card's account until it is
+// captured, voided or expires. A capture may be for less than the authorized
+// amount, in which case the rest is released. While pending, the amount is
+// not part of the account's available balance.
+type Authorization struct {
+	Id              int64     `json:"id"`
+	BankId          int64     `json:"-"`
+	CardId          int64     `json:"card_id"`
+	AccountId       int64     `json:"account_id"`
+	TargetAccountId int64     `json:"target_account_id"`
+	AmountInCents   int64     `json:"amount_in_cents"`
+	CapturedInCents int64     `json:"captured_in_cents"`
+	Status          string    `json:"status"`
+	TransferId      int64     `json:"transfer_id,omitempty"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	Version         int64     `json:"version"`
+}
+
+// pendingAuthorization is the condition for an authorization, in scope as
+// authorizations, still reserving funds.
+const pendingAuthorization = `authorizations.status = 'pending' AND authorizations.expires_at > now()`
+
+// authorizationStatusColumn reports pending authorizations past their expiry as
+// expired. It expects the authorizations table to be in scope.
+const authorizationStatusColumn = `
+        CASE
+            WHEN authorizations.status = 'pending' AND authorizations.expires_at <= now() THEN 'expired'
+            ELSE authorizations.status
+        END`
+
+type AuthorizationModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+func (m AuthorizationModel) Get(id int64, bankId int64) (*Authorization, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+        SELECT id, bank_id, card_id, account_id, target_account_id, amount_in_cents, captured_in_cents, %s, COALESCE(transfer_id, 0), expires_at, created_at, version
+        FROM authorizations
+        WHERE id = $1 AND bank_id = $2`, authorizationStatusColumn)
+
+	var authorization Authorization
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.ReadDb.QueryRowContext(ctx, query, id, bankId).Scan(
+		&authorization.Id,
+		&authorization.BankId,
+		&authorization.CardId,
+		&authorization.AccountId,
+		&authorization.TargetAccountId,
+		&authorization.AmountInCents,
+		&authorization.CapturedInCents,
+		&authorization.Status,
+		&authorization.TransferId,
+		&authorization.ExpiresAt,
+		&authorization.CreatedAt,
+		&authorization.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &authorization, nil
+}
+
+// Capture settles authorization for amountInCents, which may be less than the
+// authorized amount, or for all of it if amountInCents is 0. The authorization
+// row is locked for the duration of the transaction, so it can only ever be
+// captured once. It is marked captured before the transfer is posted, so its
+// own reservation doesn't count against the account's available balance.
+// frozenBankPayments decides whether a capture into a frozen bank's account
+// goes through.
+func (m AuthorizationModel) Capture(authorization *Authorization, amountInCents int64, frozenBankPayments string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockPendingAuthorization(ctx, tx, authorization)
+	if err != nil {
+		return err
+	}
+
+	if amountInCents == 0 {
+		amountInCents = authorization.AmountInCents
+	}
+
+	if amountInCents > authorization.AmountInCents {
+		return ErrCaptureExceedsAmount
+	}
+
+	query := `
+        UPDATE authorizations
+        SET status = $1, captured_in_cents = $2, version = version + 1
+        WHERE id = $3`
+
+	_, err = tx.ExecContext(ctx, query, AuthorizationStatusCaptured, amountInCents, authorization.Id)
+	if err != nil {
+		return err
+	}
+
+	var bankId int64
+
+	err = tx.QueryRowContext(ctx, `SELECT bank_id FROM accounts WHERE id = $1`, authorization.AccountId).Scan(&bankId)
+	if err != nil {
+		return err
+	}
+
+	transfer := &Transfer{
+		SourceAccountId: authorization.AccountId,
+		TargetAccountId: authorization.TargetAccountId,
+		AmountInCents:   amountInCents,
+	}
+
+	err = insertTransfer(ctx, tx, transfer, bankId, frozenBankPayments)
+	if err != nil {
+		return err
+	}
+
+	query = `
+        UPDATE authorizations
+        SET transfer_id = $1
+        WHERE id = $2
+        RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, transfer.Id, authorization.Id).Scan(&authorization.Version)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	authorization.Status = AuthorizationStatusCaptured
+	authorization.CapturedInCents = amountInCents
+	authorization.TransferId = transfer.Id
+
+	return nil
+}
+
+// Void releases the funds reserved by a pending authorization.
+func (m AuthorizationModel) Void(authorization *Authorization) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockPendingAuthorization(ctx, tx, authorization)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE authorizations
+        SET status = $1, version = version + 1
+        WHERE id = $2
+        RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, AuthorizationStatusVoided, authorization.Id).Scan(&authorization.Version)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	authorization.Status = AuthorizationStatusVoided
+
+	return nil
+}
+
+func (m AuthorizationModel) GetAll(bankId int64, status string, filters Filters) ([]*Authorization, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, bank_id, card_id, account_id, target_account_id, amount_in_cents, captured_in_cents, %s, COALESCE(transfer_id, 0), expires_at, created_at, version
+        FROM authorizations
+        WHERE bank_id = $1
+        AND ($2::text = '' OR %s = $2)
+        ORDER BY %s %s, id ASC
+        LIMIT $3 OFFSET $4`, authorizationStatusColumn, authorizationStatusColumn, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, bankId, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	authorizations := []*Authorization{}
+
+	for rows.Next() {
+		var authorization Authorization
+
+		err := rows.Scan(
+			&totalRecords,
+			&authorization.Id,
+			&authorization.BankId,
+			&authorization.CardId,
+			&authorization.AccountId,
+			&authorization.TargetAccountId,
+			&authorization.AmountInCents,
+			&authorization.CapturedInCents,
+			&authorization.Status,
+			&authorization.TransferId,
+			&authorization.ExpiresAt,
+			&authorization.CreatedAt,
+			&authorization.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		authorizations = append(authorizations, &authorization)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return authorizations, metadata, nil
+}
+
+// lockPendingAuthorization locks authorization's row and refreshes it, failing
+// unless it is still pending and unexpired.
+func lockPendingAuthorization(ctx context.Context, tx *sql.Tx, authorization *Authorization) error {
+	query := `
+        SELECT card_id, account_id, target_account_id, amount_in_cents, status, expires_at, created_at, version
+        FROM authorizations
+        WHERE id = $1 AND bank_id = $2
+        FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, query, authorization.Id, authorization.BankId).Scan(
+		&authorization.CardId,
+		&authorization.AccountId,
+		&authorization.TargetAccountId,
+		&authorization.AmountInCents,
+		&authorization.Status,
+		&authorization.ExpiresAt,
+		&authorization.CreatedAt,
+		&authorization.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	switch {
+	case authorization.Status != AuthorizationStatusPending:
+		return ErrAuthorizationNotPending
+	case time.Now().After(authorization.ExpiresAt):
+		authorization.Status = AuthorizationStatusExpired
+		return ErrAuthorizationExpired
+	}
+
+	return nil
+}
+
+// insertAuthorization reserves authorization.AmountInCents on the card's
+// account inside tx. The account is locked so the reservation can't race a
+// debit, and the amount must fit within its available balance.
+func insertAuthorization(ctx context.Context, tx *sql.Tx, authorization *Authorization) error {
+	query := fmt.Sprintf(`
+        SELECT bank_id, balance_in_cents - %s, %s
+        FROM accounts
+        WHERE id = $1
+        FOR UPDATE`, accountHeldColumn, accountStatusColumn)
+
+	var account Account
+
+	err := tx.QueryRowContext(ctx, query, authorization.AccountId).Scan(&account.BankId, &account.AvailableInCents, &account.Status)
+	if err != nil {
+		return err
+	}
+
+	err = account.usable()
+	if err != nil {
+		return err
+	}
+
+	err = checkBankNotFrozen(ctx, tx, account.BankId)
+	if err != nil {
+		return err
+	}
+
+	if account.AvailableInCents < authorization.AmountInCents {
+		return ErrInsufficientFunds
+	}
+
+	query = `
+        INSERT INTO authorizations (bank_id, card_id, account_id, target_account_id, amount_in_cents, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at, version`
+
+	args := []interface{}{
+		authorization.BankId,
+		authorization.CardId,
+		authorization.AccountId,
+		authorization.TargetAccountId,
+		authorization.AmountInCents,
+		authorization.ExpiresAt,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&authorization.Id, &authorization.CreatedAt, &authorization.Version)
+	if err != nil {
+		return err
+	}
+
+	authorization.Status = AuthorizationStatusPending
+
+	return nil
+}
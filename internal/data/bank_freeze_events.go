@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+var (
+	ErrBankFrozen        = errors.New("bank frozen")
+	ErrTargetBankFrozen  = errors.New("target bank frozen")
+	ErrBankAlreadyFrozen = errors.New("bank already frozen")
+	ErrBankNotFrozen     = errors.New("bank not frozen")
+)
+
+const (
+	BankFreezeActionFrozen   = "frozen"
+	BankFreezeActionUnfrozen = "unfrozen"
+)
+
+// A BankFreezeEvent records the central bank freezing or unfreezing a bank,
+// and why. ActorUserId is 0 when the central bank used its own credentials.
+type BankFreezeEvent struct {
+	Id          int64     `json:"id"`
+	BankId      int64     `json:"bank_id"`
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason"`
+	ActorBankId int64     `json:"actor_bank_id"`
+	ActorUserId int64     `json:"actor_user_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func ValidateBankFreezeReason(v *validator.Validator, reason string) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(utf8.RuneCountInString(reason) <= 500, "reason", "must not be more than 500 characters long")
+}
+
+// SetFrozen freezes or unfreezes bank on behalf of actorBankId, and
+// actorUserId when a user made the request, and records why. It fails if the
+// bank is already in the requested state.
+func (m BankModel) SetFrozen(bank *Bank, frozen bool, reason string, actorBankId int64, actorUserId int64) (*BankFreezeEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE banks
+        SET frozen = $1, version = version + 1
+        WHERE id = $2 AND frozen = NOT $1
+        RETURNING balance_in_cents, version`
+
+	err = tx.QueryRowContext(ctx, query, frozen, bank.Id).Scan(&bank.BalanceInCents, &bank.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && frozen:
+			return nil, ErrBankAlreadyFrozen
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrBankNotFrozen
+		default:
+			return nil, err
+		}
+	}
+
+	event := &BankFreezeEvent{
+		BankId:      bank.Id,
+		Action:      BankFreezeActionUnfrozen,
+		Reason:      reason,
+		ActorBankId: actorBankId,
+		ActorUserId: actorUserId,
+	}
+
+	if frozen {
+		event.Action = BankFreezeActionFrozen
+	}
+
+	query = `
+        INSERT INTO bank_freeze_events (bank_id, action, reason, actor_bank_id, actor_user_id)
+        VALUES ($1, $2, $3, $4, NULLIF($5::bigint, 0))
+        RETURNING id, created_at`
+
+	args := []interface{}{event.BankId, event.Action, event.Reason, event.ActorBankId, event.ActorUserId}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&event.Id, &event.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	bank.Frozen = frozen
+
+	return event, nil
+}
+
+func (m BankModel) GetFreezeEvents(bankId int64, filters Filters) ([]*BankFreezeEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, bank_id, action, reason, actor_bank_id, COALESCE(actor_user_id, 0), created_at
+        FROM bank_freeze_events
+        WHERE bank_id = $1
+        ORDER BY %s %s, id ASC
+        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, bankId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*BankFreezeEvent{}
+
+	for rows.Next() {
+		var event BankFreezeEvent
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.Id,
+			&event.BankId,
+			&event.Action,
+			&event.Reason,
+			&event.ActorBankId,
+			&event.ActorUserId,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// checkTargetBank turns away money moving into bankId while it is frozen,
+// unless frozenBankPayments accepts it.
+func checkTargetBank(ctx context.Context, tx *sql.Tx, bankId int64, frozenBankPayments string) error {
+	if frozenBankPayments != FrozenBankPaymentsReject {
+		return nil
+	}
+
+	err := checkBankNotFrozen(ctx, tx, bankId)
+	if errors.Is(err, ErrBankFrozen) {
+		return ErrTargetBankFrozen
+	}
+
+	return err
+}
+
+// checkBankNotFrozen stops money leaving the accounts of bankId while the
+// central bank has it frozen.
+func checkBankNotFrozen(ctx context.Context, tx *sql.Tx, bankId int64) error {
+	var frozen bool
+
+	err := tx.QueryRowContext(ctx, `SELECT frozen FROM banks WHERE id = $1`, bankId).Scan(&frozen)
+	if err != nil {
+		return err
+	}
+
+	if frozen {
+		return ErrBankFrozen
+	}
+
+	return nil
+}
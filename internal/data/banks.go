@@ -5,24 +5,48 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"time"
 	"unicode/utf8"
 
 	"github.com/calmitchell617/reserva/internal/validator"
+	"github.com/lib/pq"
 )
 
 var (
-	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrDuplicateEmail       = errors.New("duplicate email")
+	ErrDuplicateName        = errors.New("duplicate name")
+	ErrDuplicateCentralBank = errors.New("duplicate central bank")
+)
+
+const (
+	BankStatusPending = "pending"
+	BankStatusActive  = "active"
+	BankStatusFrozen  = "frozen"
 )
 
 var AnonymousBank = &Bank{}
 
+// bankStatusColumn sums up a bank's activated and frozen flags as one of the
+// bank statuses, for filtering.
+const bankStatusColumn = `
+        CASE
+            WHEN banks.frozen THEN 'frozen'
+            WHEN NOT banks.activated THEN 'pending'
+            ELSE 'active'
+        END`
+
 type Bank struct {
 	Id             int64    `json:"id"`
 	Name           string   `json:"name"`
 	Email          string   `json:"email"`
+	SourceIps      []string `json:"source_ips"`
 	Password       password `json:"-"`
 	BalanceInCents int64    `json:"balance_in_cents"`
+	Role           string   `json:"role"`
+	Permissions    []string `json:"permissions"`
 	Activated      bool     `json:"activated"`
 	Frozen         bool     `json:"frozen"`
 	Version        int64    `json:"-"`
@@ -32,6 +56,47 @@ func (u *Bank) IsAnonymous() bool {
 	return u == AnonymousBank
 }
 
+// AllowsIP reports whether ip falls within one of the bank's source IPs, each
+// of which is a single address or a CIDR block. An empty list allows any
+// address.
+func (u *Bank) AllowsIP(ip string) bool {
+	if len(u.SourceIps) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, source := range u.SourceIps {
+		if strings.Contains(source, "/") {
+			_, network, err := net.ParseCIDR(source)
+			if err == nil && network.Contains(addr) {
+				return true
+			}
+			continue
+		}
+
+		if addr.Equal(net.ParseIP(source)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidateSourceIps(v *validator.Validator, ips []string) {
+	v.Check(ips != nil, "source_ips", "must be provided")
+	v.Check(len(ips) <= 50, "source_ips", "must not contain more than 50 entries")
+	v.Check(validator.Unique(ips), "source_ips", "must not contain duplicate values")
+
+	for _, ip := range ips {
+		_, _, err := net.ParseCIDR(ip)
+		v.Check(err == nil || net.ParseIP(ip) != nil, "source_ips", "must only contain IP addresses or CIDR blocks")
+	}
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -49,6 +114,8 @@ func ValidateBank(v *validator.Validator, bank *Bank) {
 
 	ValidateEmail(v, bank.Email)
 
+	v.Check(validator.PermittedValue(bank.Role, Roles...), "role", "must be one of central_bank, commercial_bank or psp")
+
 	if bank.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *bank.Password.plaintext)
 	}
@@ -65,20 +132,30 @@ type BankModel struct {
 
 func (m BankModel) Insert(bank *Bank) error {
 	query := `
-        INSERT INTO banks (name, email, password_hash) 
-        VALUES ($1, $2, $3)
+        INSERT INTO banks (name, email, password_hash, role, ips) 
+        VALUES ($1, $2, $3, $4, $5::inet[])
         RETURNING id, version`
 
-	args := []interface{}{bank.Name, bank.Email, bank.Password.hash}
+	if bank.SourceIps == nil {
+		bank.SourceIps = []string{}
+	}
+
+	args := []interface{}{bank.Name, bank.Email, bank.Password.hash, bank.Role, pq.Array(bank.SourceIps)}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	bank.Permissions = permissionsFor(bank.Role)
+
 	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&bank.Id, &bank.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "banks_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "banks_name_key"`:
+			return ErrDuplicateName
+		case err.Error() == `pq: duplicate key value violates unique constraint "banks_central_idx"`:
+			return ErrDuplicateCentralBank
 		default:
 			return err
 		}
@@ -87,14 +164,84 @@ func (m BankModel) Insert(bank *Bank) error {
 	return nil
 }
 
+func (m BankModel) CentralBankExists() (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM banks WHERE role = 'central_bank')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.ReadDb.QueryRowContext(ctx, query).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (m BankModel) Get(id int64) (*Bank, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT
+					id,
+					name,
+					email,
+					ips::text[],
+					password_hash,
+					balance_in_cents,
+					role,
+					activated,
+					frozen,
+					version
+        FROM banks
+        WHERE id = $1`
+
+	var bank Bank
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.ReadDb.QueryRowContext(ctx, query, id).Scan(
+		&bank.Id,
+		&bank.Name,
+		&bank.Email,
+		pq.Array(&bank.SourceIps),
+		&bank.Password.hash,
+		&bank.BalanceInCents,
+		&bank.Role,
+		&bank.Activated,
+		&bank.Frozen,
+		&bank.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	bank.Permissions = permissionsFor(bank.Role)
+
+	return &bank, nil
+}
+
 func (m BankModel) GetByEmail(email string) (*Bank, error) {
 	query := `
         SELECT
 					id,
 					name,
 					email,
+					ips::text[],
 					password_hash,
 					balance_in_cents,
+					role,
 					activated,
 					frozen,
 					version
@@ -110,8 +257,10 @@ func (m BankModel) GetByEmail(email string) (*Bank, error) {
 		&bank.Id,
 		&bank.Name,
 		&bank.Email,
+		pq.Array(&bank.SourceIps),
 		&bank.Password.hash,
 		&bank.BalanceInCents,
+		&bank.Role,
 		&bank.Activated,
 		&bank.Frozen,
 		&bank.Version,
@@ -126,6 +275,8 @@ func (m BankModel) GetByEmail(email string) (*Bank, error) {
 		}
 	}
 
+	bank.Permissions = permissionsFor(bank.Role)
+
 	return &bank, nil
 }
 
@@ -136,20 +287,26 @@ func (m BankModel) Update(bank *Bank) error {
 					name = $1,
 					email = $2,
 					password_hash = $3,
-					balance_in_cents = $4,
-					activated = $5,
-					frozen = $6,
+					activated = $4,
+					frozen = $5,
+					ips = $6::inet[],
+					role = $7,
 					version = version + 1
-        WHERE id = $7 AND version = $8
-        RETURNING version`
+        WHERE id = $8 AND version = $9
+        RETURNING balance_in_cents, version`
+
+	if bank.SourceIps == nil {
+		bank.SourceIps = []string{}
+	}
 
 	args := []interface{}{
 		bank.Name,
 		bank.Email,
 		bank.Password.hash,
-		bank.BalanceInCents,
 		bank.Activated,
 		bank.Frozen,
+		pq.Array(bank.SourceIps),
+		bank.Role,
 		bank.Id,
 		bank.Version,
 	}
@@ -157,11 +314,13 @@ func (m BankModel) Update(bank *Bank) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&bank.Version)
+	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&bank.BalanceInCents, &bank.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "banks_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "banks_central_idx"`:
+			return ErrDuplicateCentralBank
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
@@ -169,9 +328,70 @@ func (m BankModel) Update(bank *Bank) error {
 		}
 	}
 
+	bank.Permissions = permissionsFor(bank.Role)
+
 	return nil
 }
 
+func (m BankModel) GetAll(name string, email string, role string, status string, filters Filters) ([]*Bank, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, name, email, ips::text[], balance_in_cents, role, activated, frozen, version
+        FROM banks
+        WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1::text = '')
+        AND ($2::text = '' OR email = $2)
+        AND ($3::text = '' OR role = $3)
+        AND ($4::text = '' OR %s = $4)
+        ORDER BY %s %s, id ASC
+        LIMIT $5 OFFSET $6`, bankStatusColumn, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{name, email, role, status, filters.limit(), filters.offset()}
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	banks := []*Bank{}
+
+	for rows.Next() {
+		var bank Bank
+
+		err := rows.Scan(
+			&totalRecords,
+			&bank.Id,
+			&bank.Name,
+			&bank.Email,
+			pq.Array(&bank.SourceIps),
+			&bank.BalanceInCents,
+			&bank.Role,
+			&bank.Activated,
+			&bank.Frozen,
+			&bank.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		bank.Permissions = permissionsFor(bank.Role)
+
+		banks = append(banks, &bank)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return banks, metadata, nil
+}
+
 func (m BankModel) GetForToken(tokenScope, tokenPlaintext string) (*Bank, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
@@ -180,8 +400,10 @@ func (m BankModel) GetForToken(tokenScope, tokenPlaintext string) (*Bank, error)
 					banks.id,
 					banks.name,
 					banks.email,
+					banks.ips::text[],
 					banks.password_hash,
 					banks.balance_in_cents,
+					banks.role,
 					banks.activated,
 					banks.frozen,
 					banks.version
@@ -190,7 +412,8 @@ func (m BankModel) GetForToken(tokenScope, tokenPlaintext string) (*Bank, error)
         ON banks.id = tokens.bank_id
         WHERE tokens.hash = $1
         AND tokens.scope = $2 
-        AND tokens.expiry > $3`
+        AND tokens.expiry > $3
+        AND tokens.user_id IS NULL`
 
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
@@ -203,8 +426,10 @@ func (m BankModel) GetForToken(tokenScope, tokenPlaintext string) (*Bank, error)
 		&bank.Id,
 		&bank.Name,
 		&bank.Email,
+		pq.Array(&bank.SourceIps),
 		&bank.Password.hash,
 		&bank.BalanceInCents,
+		&bank.Role,
 		&bank.Activated,
 		&bank.Frozen,
 		&bank.Version,
@@ -218,5 +443,7 @@ func (m BankModel) GetForToken(tokenScope, tokenPlaintext string) (*Bank, error)
 		}
 	}
 
+	bank.Permissions = permissionsFor(bank.Role)
+
 	return &bank, nil
 }
@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrOverlappingBin = errors.New("overlapping bin")
+)
+
+var (
+	BinPrefixRX = regexp.MustCompile("^[1-9][0-9]{0,7}$")
+)
+
+// A Bin is a card number range, identified by its leading digits, that is
+// assigned to a single issuing bank. Ranges never overlap, so any card number
+// routes to at most one bank.
+type Bin struct {
+	Id         int64     `json:"id"`
+	BankId     int64     `json:"bank_id"`
+	BankName   string    `json:"bank_name,omitempty"`
+	Prefix     string    `json:"prefix"`
+	CardLength int       `json:"card_length"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// LegacyBin is the shared range every card was issued from before banks had
+// ranges of their own. Banks without a range still issue from it, so no bank
+// can be assigned a range that overlaps it.
+var LegacyBin = Bin{Prefix: "29", CardLength: 16}
+
+func ValidateBin(v *validator.Validator, bin *Bin) {
+	v.Check(bin.BankId != 0, "bank_id", "must be provided")
+	v.Check(bin.BankId > 0, "bank_id", "must be greater than 0")
+	v.Check(validator.Matches(bin.Prefix, BinPrefixRX), "prefix", "must be 1 to 8 digits and must not start with 0")
+	v.Check(bin.CardLength >= 12, "card_length", "must be at least 12")
+	v.Check(bin.CardLength <= 18, "card_length", "must not be more than 18")
+	v.Check(len(bin.Prefix) <= bin.CardLength-7, "prefix", "must leave at least 6 random digits and a check digit")
+	v.Check(!strings.HasPrefix(bin.Prefix, LegacyBin.Prefix) && !strings.HasPrefix(LegacyBin.Prefix, bin.Prefix), "prefix", "must not overlap the legacy 29 range")
+}
+
+type BinModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+// Insert assigns bin to its bank. The overlap check runs in a serializable
+// transaction so two overlapping ranges can't be inserted at once, and
+// transactions that lose that race are retried. ErrEditConflict is returned
+// if they keep losing.
+func (m BinModel) Insert(bin *Bin) error {
+	var err error
+
+	for i := 0; i < 3; i++ {
+		err = m.insert(bin)
+
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr.Code != "40001" {
+			return err
+		}
+	}
+
+	return ErrEditConflict
+}
+
+func (m BinModel) insert(bin *Bin) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var overlapping bool
+
+	query := `
+        SELECT EXISTS (
+            SELECT 1 FROM bins
+            WHERE $1 LIKE prefix || '%' OR prefix LIKE $1 || '%'
+        )`
+
+	err = tx.QueryRowContext(ctx, query, bin.Prefix).Scan(&overlapping)
+	if err != nil {
+		return err
+	}
+
+	if overlapping {
+		return ErrOverlappingBin
+	}
+
+	query = `
+        INSERT INTO bins (bank_id, prefix, card_length)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, bin.BankId, bin.Prefix, bin.CardLength).Scan(&bin.Id, &bin.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "bins" violates foreign key constraint "bins_bank_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get returns one of bankId's bins. If id is 0 it returns the bank's oldest
+// bin, which is the default range cards are issued from.
+func (m BinModel) Get(id int64, bankId int64) (*Bin, error) {
+	query := `
+        SELECT id, bank_id, prefix, card_length, created_at
+        FROM bins
+        WHERE ($1::bigint = 0 OR id = $1) AND bank_id = $2
+        ORDER BY id
+        LIMIT 1`
+
+	var bin Bin
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.ReadDb.QueryRowContext(ctx, query, id, bankId).Scan(
+		&bin.Id,
+		&bin.BankId,
+		&bin.Prefix,
+		&bin.CardLength,
+		&bin.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &bin, nil
+}
+
+func (m BinModel) GetForCardNumber(number int64) (*Bin, error) {
+	query := `
+        SELECT bins.id, bins.bank_id, banks.name, bins.prefix, bins.card_length, bins.created_at
+        FROM bins
+        INNER JOIN banks ON bins.bank_id = banks.id
+        WHERE $1 LIKE bins.prefix || '%' AND length($1) = bins.card_length
+        ORDER BY length(bins.prefix) DESC
+        LIMIT 1`
+
+	var bin Bin
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.ReadDb.QueryRowContext(ctx, query, strconv.FormatInt(number, 10)).Scan(
+		&bin.Id,
+		&bin.BankId,
+		&bin.BankName,
+		&bin.Prefix,
+		&bin.CardLength,
+		&bin.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &bin, nil
+}
+
+func (m BinModel) GetAllForBank(bankId int64) ([]*Bin, error) {
+	query := `
+        SELECT id, bank_id, prefix, card_length, created_at
+        FROM bins
+        WHERE bank_id = $1
+        ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, bankId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	bins := []*Bin{}
+
+	for rows.Next() {
+		var bin Bin
+
+		err := rows.Scan(
+			&bin.Id,
+			&bin.BankId,
+			&bin.Prefix,
+			&bin.CardLength,
+			&bin.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		bins = append(bins, &bin)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bins, nil
+}
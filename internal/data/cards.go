@@ -7,24 +7,65 @@ import (
 	"errors"
 	"fmt"
 	"time"
+	"unicode/utf8"
 
 	"github.com/calmitchell617/reserva/internal/validator"
 )
 
 var (
 	ErrDuplicateCardId = errors.New("duplicate card number")
+	ErrCardInactive    = errors.New("card inactive")
+	ErrCardLocked      = errors.New("card locked")
+	ErrIncorrectPin    = errors.New("incorrect pin")
 )
 
+const (
+	CardStatusActive   = "active"
+	CardStatusBlocked  = "blocked"
+	CardStatusReplaced = "replaced"
+)
+
+// Card only holds the public half of the card's key pair. The private key is
+// handed to the issuing bank once, when the card is created, and never stored.
 type Card struct {
-	Id         int64              `json:"id"`
-	AccountId  int64              `json:"account_id"`
-	PrivateKey ed25519.PrivateKey `json:"-"`
-	Password   password           `json:"-"`
-	Expiry     time.Time          `json:"expiry"`
-	Version    int64              `json:"version"`
+	Id                int64             `json:"id"`
+	AccountId         int64             `json:"account_id"`
+	PublicKey         ed25519.PublicKey `json:"public_key"`
+	Password          password          `json:"-"`
+	Expiry            time.Time         `json:"expiry"`
+	Status            string            `json:"status"`
+	BlockedReason     string            `json:"blocked_reason,omitempty"`
+	ReplacedBy        int64             `json:"replaced_by,omitempty"`
+	FailedPinAttempts int               `json:"failed_pin_attempts"`
+	Locked            bool              `json:"locked"`
+	Version           int64             `json:"version"`
+}
+
+// Verify reports whether signature is the card's signature of message.
+func (c *Card) Verify(message, signature []byte) bool {
+	if len(c.PublicKey) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(c.PublicKey, message, signature)
+}
+
+func ValidatePin(v *validator.Validator, pin string) {
+	v.Check(pin != "", "pin", "must be provided")
+	v.Check(utf8.RuneCountInString(pin) <= 72, "pin", "must not be more than 72 characters long")
+}
+
+func ValidateBlockedReason(v *validator.Validator, reason string) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(utf8.RuneCountInString(reason) <= 500, "reason", "must not be more than 500 characters long")
 }
 
 func ValidateCard(v *validator.Validator, card *Card) {
+	v.Check(card.AccountId != 0, "account_id", "must be provided")
+	v.Check(card.AccountId > 0, "account_id", "must be greater than 0")
+	v.Check(card.Expiry.After(time.Now()), "expiry_in_days", "must be greater than 0")
+	v.Check(len(card.PublicKey) == ed25519.PublicKeySize, "public_key", "must be an ed25519 public key")
+
 	if card.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *card.Password.plaintext)
 	}
@@ -40,26 +81,43 @@ type CardModel struct {
 }
 
 func (m CardModel) Insert(card *Card, bankId int64) error {
-	query := `
-				insert into cards (id, account_id, private_key, password_hash, expiry)
-				select $1, $2, $3, $4, $5 from accounts where bank_id = $6
-        RETURNING id, version`
-
-	args := []interface{}{card.Id, card.AccountId, card.PrivateKey, card.Password.hash, card.Expiry, bankId}
+	return m.InsertBatch([]*Card{card}, bankId)
+}
 
+// InsertBatch stores cards in a single transaction, so either every card in a
+// personalization batch is created or none are.
+func (m CardModel) InsertBatch(cards []*Card, bankId int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&card.Id, &card.Version)
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "cards_id_key"`:
-			return ErrDuplicateCardId
-		default:
-			return err
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO cards (id, account_id, public_key, password_hash, expiry)
+        SELECT $1, id, $3, $4, $5 FROM accounts WHERE id = $2 AND bank_id = $6 AND status <> 'closed'
+        RETURNING id, status, version`
+
+	for _, card := range cards {
+		args := []interface{}{card.Id, card.AccountId, card.PublicKey, card.Password.hash, card.Expiry, bankId}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&card.Id, &card.Status, &card.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "cards_pkey"`:
+				return ErrDuplicateCardId
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
 		}
 	}
-	return nil
+
+	return tx.Commit()
 }
 
 func (m CardModel) Get(id int64, bankId int64) (*Card, error) {
@@ -68,10 +126,10 @@ func (m CardModel) Get(id int64, bankId int64) (*Card, error) {
 	}
 
 	query := `
-        SELECT cards.id, cards.account_id, cards.private_key, cards.password_hash, cards.expiry, cards.version
+        SELECT cards.id, cards.account_id, cards.public_key, cards.password_hash, cards.expiry, cards.status, cards.blocked_reason, COALESCE(cards.replaced_by, 0), cards.failed_pin_attempts, cards.locked, cards.version
         FROM cards
-				inner join accounts on cards.account_id = accounts.id
-        WHERE cards.id = $1 and accounts.bank_id = $2`
+        INNER JOIN accounts ON cards.account_id = accounts.id
+        WHERE cards.id = $1 AND accounts.bank_id = $2`
 
 	var card Card
 
@@ -81,8 +139,14 @@ func (m CardModel) Get(id int64, bankId int64) (*Card, error) {
 	err := m.ReadDb.QueryRowContext(ctx, query, id, bankId).Scan(
 		&card.Id,
 		&card.AccountId,
-		&card.PrivateKey,
+		&card.PublicKey,
 		&card.Password.hash,
+		&card.Expiry,
+		&card.Status,
+		&card.BlockedReason,
+		&card.ReplacedBy,
+		&card.FailedPinAttempts,
+		&card.Locked,
 		&card.Version,
 	)
 
@@ -101,14 +165,18 @@ func (m CardModel) Get(id int64, bankId int64) (*Card, error) {
 func (m CardModel) Update(card *Card, bankId int64) error {
 	query := `
         UPDATE cards
-        SET cards.password_hash = $1, cards.version = cards.version + 1
-				from accounts
-				where cards.account_id = accounts.id
-        WHERE cards.id = $2 and accounts.bank_id = $3 and cards.version = $4
+        SET password_hash = $1, status = $2, blocked_reason = $3, failed_pin_attempts = $4, locked = $5, version = cards.version + 1
+        FROM accounts
+        WHERE cards.account_id = accounts.id
+        AND cards.id = $6 AND accounts.bank_id = $7 AND cards.version = $8
         RETURNING cards.version`
 
 	args := []interface{}{
 		card.Password.hash,
+		card.Status,
+		card.BlockedReason,
+		card.FailedPinAttempts,
+		card.Locked,
 		card.Id,
 		bankId,
 		card.Version,
@@ -130,6 +198,102 @@ func (m CardModel) Update(card *Card, bankId int64) error {
 	return nil
 }
 
+// Replace retires card and issues replacement in its place, on the same
+// account and with the same PIN and PIN lockout. Replaced cards can't be used
+// or replaced again.
+func (m CardModel) Replace(card *Card, replacement *Card, bankId int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT cards.account_id, cards.public_key, cards.password_hash, cards.expiry, cards.status, cards.blocked_reason, cards.failed_pin_attempts, cards.locked, accounts.status
+        FROM cards
+        INNER JOIN accounts ON cards.account_id = accounts.id
+        WHERE cards.id = $1 AND accounts.bank_id = $2
+        FOR UPDATE OF cards`
+
+	var accountStatus string
+
+	err = tx.QueryRowContext(ctx, query, card.Id, bankId).Scan(
+		&card.AccountId,
+		&card.PublicKey,
+		&card.Password.hash,
+		&card.Expiry,
+		&card.Status,
+		&card.BlockedReason,
+		&card.FailedPinAttempts,
+		&card.Locked,
+		&accountStatus,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	switch {
+	case card.Status == CardStatusReplaced:
+		return ErrCardInactive
+	case accountStatus == AccountStatusClosed:
+		return ErrAccountClosed
+	}
+
+	replacement.AccountId = card.AccountId
+	replacement.Password.hash = card.Password.hash
+
+	// the PIN carries over, so its lockout must too or a replacement would
+	// reset the attempts left to guess it
+	replacement.FailedPinAttempts = card.FailedPinAttempts
+	replacement.Locked = card.Locked
+
+	query = `
+        INSERT INTO cards (id, account_id, public_key, password_hash, expiry, failed_pin_attempts, locked)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING status, version`
+
+	args := []interface{}{replacement.Id, replacement.AccountId, replacement.PublicKey, replacement.Password.hash, replacement.Expiry, replacement.FailedPinAttempts, replacement.Locked}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&replacement.Status, &replacement.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "cards_pkey"`:
+			return ErrDuplicateCardId
+		default:
+			return err
+		}
+	}
+
+	query = `
+        UPDATE cards
+        SET status = $1, replaced_by = $2, version = version + 1
+        WHERE id = $3
+        RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, CardStatusReplaced, replacement.Id, card.Id).Scan(&card.Version)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	card.Status = CardStatusReplaced
+	card.ReplacedBy = replacement.Id
+
+	return nil
+}
+
 func (m CardModel) Delete(id int64, bankId int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
@@ -137,9 +301,9 @@ func (m CardModel) Delete(id int64, bankId int64) error {
 
 	query := `
         DELETE FROM cards
-				using accounts
-				where cards.account_id = accounts.id
-        WHERE cards.id = $1 and accounts.bank_id = $2`
+        USING accounts
+        WHERE cards.account_id = accounts.id
+        AND cards.id = $1 AND accounts.bank_id = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -161,20 +325,21 @@ func (m CardModel) Delete(id int64, bankId int64) error {
 	return nil
 }
 
-func (m CardModel) GetAll(bankId int64, filters Filters) ([]*Card, Metadata, error) {
+func (m CardModel) GetAll(bankId int64, accountId int64, status string, filters Filters) ([]*Card, Metadata, error) {
 	query := fmt.Sprintf(`
-        SELECT count(*) OVER(), id, account_id, private_key, password_hash, expiry, version
+        SELECT count(*) OVER(), cards.id, cards.account_id, cards.public_key, cards.password_hash, cards.expiry, cards.status, cards.blocked_reason, COALESCE(cards.replaced_by, 0), cards.failed_pin_attempts, cards.locked, cards.version
         FROM cards
-				inner join accounts on cards.account_id = accounts.id
-        where bank_id = $1
-				and accounts.bank_id = $2
-        ORDER BY %s %s, id ASC
-        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+        INNER JOIN accounts ON cards.account_id = accounts.id
+        WHERE accounts.bank_id = $1
+        AND ($2::bigint = 0 OR cards.account_id = $2)
+        AND ($3::text = '' OR cards.status = $3)
+        ORDER BY cards.%s %s, cards.id ASC
+        LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{bankId, filters.limit(), filters.offset()}
+	args := []interface{}{bankId, accountId, status, filters.limit(), filters.offset()}
 
 	rows, err := m.ReadDb.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -193,9 +358,14 @@ func (m CardModel) GetAll(bankId int64, filters Filters) ([]*Card, Metadata, err
 			&totalRecords,
 			&card.Id,
 			&card.AccountId,
-			&card.PrivateKey,
+			&card.PublicKey,
 			&card.Password.hash,
 			&card.Expiry,
+			&card.Status,
+			&card.BlockedReason,
+			&card.ReplacedBy,
+			&card.FailedPinAttempts,
+			&card.Locked,
 			&card.Version,
 		)
 		if err != nil {
@@ -0,0 +1,262 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDepositorNotFound = errors.New("depositor not found")
+)
+
+type Depositor struct {
+	Id         int64     `json:"id"`
+	BankId     int64     `json:"bank_id"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	ExternalId string    `json:"external_id"`
+	KycTier    string    `json:"kyc_tier"`
+	Accounts   []int64   `json:"accounts"`
+	CreatedAt  time.Time `json:"created_at"`
+	Version    int64     `json:"version"`
+}
+
+func ValidateDepositor(v *validator.Validator, depositor *Depositor) {
+	v.Check(depositor.Name != "", "name", "must be provided")
+	v.Check(utf8.RuneCountInString(depositor.Name) <= 500, "name", "must not be more than 500 characters long")
+	v.Check(utf8.RuneCountInString(depositor.ExternalId) <= 255, "external_id", "must not be more than 255 characters long")
+	v.Check(validator.PermittedValue(depositor.KycTier, KycTiers...), "kyc_tier", "must be one of anonymous, basic or full")
+
+	ValidateEmail(v, depositor.Email)
+}
+
+func ValidateDepositorIds(v *validator.Validator, ids []int64) {
+	v.Check(len(ids) <= 20, "depositors", "must not contain more than 20 depositors")
+	v.Check(validator.Unique(ids), "depositors", "must not contain duplicate values")
+
+	for _, id := range ids {
+		v.Check(id > 0, "depositors", "must only contain ids greater than 0")
+	}
+}
+
+type DepositorModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+// depositorAccountsColumn lists the accounts a depositor is attached to. It
+// expects the depositors table to be in scope.
+const depositorAccountsColumn = `
+        COALESCE((SELECT array_agg(account_id ORDER BY account_id) FROM account_depositors WHERE depositor_id = depositors.id), '{}')`
+
+func (m DepositorModel) Insert(depositor *Depositor) error {
+	query := `
+        INSERT INTO depositors (bank_id, name, email, external_id, kyc_tier)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at, version`
+
+	args := []interface{}{depositor.BankId, depositor.Name, depositor.Email, depositor.ExternalId, depositor.KycTier}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	depositor.Accounts = []int64{}
+
+	return m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&depositor.Id, &depositor.CreatedAt, &depositor.Version)
+}
+
+func (m DepositorModel) Get(id int64, bankId int64) (*Depositor, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+        SELECT id, bank_id, name, email, external_id, kyc_tier, %s, created_at, version
+        FROM depositors
+        WHERE id = $1 AND bank_id = $2`, depositorAccountsColumn)
+
+	var depositor Depositor
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.ReadDb.QueryRowContext(ctx, query, id, bankId).Scan(
+		&depositor.Id,
+		&depositor.BankId,
+		&depositor.Name,
+		&depositor.Email,
+		&depositor.ExternalId,
+		&depositor.KycTier,
+		pq.Array(&depositor.Accounts),
+		&depositor.CreatedAt,
+		&depositor.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &depositor, nil
+}
+
+func (m DepositorModel) Update(depositor *Depositor) error {
+	query := `
+        UPDATE depositors
+        SET name = $1, email = $2, external_id = $3, kyc_tier = $4, version = version + 1
+        WHERE id = $5 AND bank_id = $6 AND version = $7
+        RETURNING version`
+
+	args := []interface{}{
+		depositor.Name,
+		depositor.Email,
+		depositor.ExternalId,
+		depositor.KycTier,
+		depositor.Id,
+		depositor.BankId,
+		depositor.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&depositor.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m DepositorModel) Delete(id int64, bankId int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM depositors
+        WHERE id = $1 AND bank_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.WriteDb.ExecContext(ctx, query, id, bankId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m DepositorModel) GetAll(bankId int64, name string, email string, filters Filters) ([]*Depositor, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, bank_id, name, email, external_id, kyc_tier, %s, created_at, version
+        FROM depositors
+        WHERE bank_id = $1
+        AND (to_tsvector('simple', name) @@ plainto_tsquery('simple', $2) OR $2::text = '')
+        AND ($3::text = '' OR email = $3)
+        ORDER BY %s %s, id ASC
+        LIMIT $4 OFFSET $5`, depositorAccountsColumn, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{bankId, name, email, filters.limit(), filters.offset()}
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	depositors := []*Depositor{}
+
+	for rows.Next() {
+		var depositor Depositor
+
+		err := rows.Scan(
+			&totalRecords,
+			&depositor.Id,
+			&depositor.BankId,
+			&depositor.Name,
+			&depositor.Email,
+			&depositor.ExternalId,
+			&depositor.KycTier,
+			pq.Array(&depositor.Accounts),
+			&depositor.CreatedAt,
+			&depositor.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		depositors = append(depositors, &depositor)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return depositors, metadata, nil
+}
+
+// setAccountDepositors replaces the depositors attached to accountId inside tx.
+// Every depositor must belong to bankId.
+func setAccountDepositors(ctx context.Context, tx *sql.Tx, accountId int64, depositorIds []int64, bankId int64) error {
+	query := `
+        SELECT count(*)
+        FROM depositors
+        WHERE id = ANY($1) AND bank_id = $2`
+
+	var found int
+
+	err := tx.QueryRowContext(ctx, query, pq.Array(depositorIds), bankId).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	if found != len(depositorIds) {
+		return ErrDepositorNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM account_depositors WHERE account_id = $1`, accountId)
+	if err != nil {
+		return err
+	}
+
+	query = `
+        INSERT INTO account_depositors (account_id, depositor_id)
+        SELECT $1, unnest($2::bigint[])`
+
+	_, err = tx.ExecContext(ctx, query, accountId, pq.Array(depositorIds))
+	return err
+}
@@ -0,0 +1,391 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+var (
+	ErrHoldNotActive = errors.New("hold not active")
+	ErrActiveHolds   = errors.New("active holds")
+)
+
+const (
+	HoldKindFreeze = "freeze"
+	HoldKindAmount = "amount"
+)
+
+const (
+	HoldActionPlaced   = "placed"
+	HoldActionReleased = "released"
+)
+
+var HoldReasonCodes = []string{"court_order", "garnishment", "tax_levy", "aml", "fraud", "sanctions", "deceased", "other"}
+
+// A Hold restricts debits from an account. A freeze blocks them entirely and
+// an amount hold keeps AmountInCents of the balance from being spent. A hold
+// is active from StartsAt until it expires or is released.
+type Hold struct {
+	Id            int64        `json:"id"`
+	AccountId     int64        `json:"account_id"`
+	Kind          string       `json:"kind"`
+	AmountInCents int64        `json:"amount_in_cents,omitempty"`
+	ReasonCode    string       `json:"reason_code"`
+	Authority     string       `json:"authority"`
+	StartsAt      time.Time    `json:"starts_at"`
+	ExpiresAt     *time.Time   `json:"expires_at,omitempty"`
+	ReleasedAt    *time.Time   `json:"released_at,omitempty"`
+	Active        bool         `json:"active"`
+	Events        []*HoldEvent `json:"events,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Version       int64        `json:"version"`
+}
+
+// A HoldEvent records a hold being placed or released. UserId is 0 when the
+// bank's own credentials were used.
+type HoldEvent struct {
+	Id        int64     `json:"id"`
+	Action    string    `json:"action"`
+	Note      string    `json:"note"`
+	UserId    int64     `json:"user_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// activeHold is the condition for a hold, in scope as holds, being in force.
+const activeHold = `holds.released_at IS NULL AND holds.starts_at <= now() AND (holds.expires_at IS NULL OR holds.expires_at > now())`
+
+// accountStatusColumn reports an open account as frozen while a freeze hold is
+// in force. It expects the accounts table to be in scope.
+var accountStatusColumn = fmt.Sprintf(`
+        CASE
+            WHEN accounts.status = 'active' AND EXISTS (SELECT 1 FROM holds WHERE holds.account_id = accounts.id AND holds.kind = 'freeze' AND %s) THEN 'frozen'
+            ELSE accounts.status
+        END`, activeHold)
+
+// accountHeldColumn is the total of the amount holds in force and the card
+// authorizations pending on an account. It expects the accounts table to be in
+// scope.
+var accountHeldColumn = fmt.Sprintf(`
+        COALESCE((SELECT sum(holds.amount_in_cents) FROM holds WHERE holds.account_id = accounts.id AND holds.kind = 'amount' AND %s), 0)
+        + COALESCE((SELECT sum(authorizations.amount_in_cents) FROM authorizations WHERE authorizations.account_id = accounts.id AND %s), 0)`, activeHold, pendingAuthorization)
+
+func ValidateHold(v *validator.Validator, hold *Hold) {
+	v.Check(validator.PermittedValue(hold.Kind, HoldKindFreeze, HoldKindAmount), "kind", "must be freeze or amount")
+
+	if hold.Kind == HoldKindAmount {
+		v.Check(hold.AmountInCents > 0, "amount_in_cents", "must be greater than 0")
+	} else {
+		v.Check(hold.AmountInCents == 0, "amount_in_cents", "must not be provided for a freeze")
+	}
+
+	v.Check(validator.PermittedValue(hold.ReasonCode, HoldReasonCodes...), "reason_code", "must be a known reason code")
+	v.Check(hold.Authority != "", "authority", "must be provided")
+	v.Check(utf8.RuneCountInString(hold.Authority) <= 500, "authority", "must not be more than 500 characters long")
+
+	if hold.ExpiresAt != nil {
+		v.Check(hold.ExpiresAt.After(hold.StartsAt), "expires_at", "must be after starts_at")
+		v.Check(hold.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+func ValidateHoldNote(v *validator.Validator, note string) {
+	v.Check(utf8.RuneCountInString(note) <= 500, "note", "must not be more than 500 characters long")
+}
+
+type HoldModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+// Insert places hold on an account of bankId on behalf of userId. The account
+// row is locked so the hold can't race a debit that it should have blocked.
+func (m HoldModel) Insert(hold *Hold, bankId int64, note string, userId int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+
+	query := `
+        SELECT status
+        FROM accounts
+        WHERE id = $1 AND bank_id = $2
+        FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, hold.AccountId, bankId).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if status == AccountStatusClosed {
+		return ErrAccountClosed
+	}
+
+	query = `
+        INSERT INTO holds (account_id, kind, amount_in_cents, reason_code, authority, starts_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, created_at, version`
+
+	args := []interface{}{hold.AccountId, hold.Kind, hold.AmountInCents, hold.ReasonCode, hold.Authority, hold.StartsAt, hold.ExpiresAt}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&hold.Id, &hold.CreatedAt, &hold.Version)
+	if err != nil {
+		return err
+	}
+
+	event, err := insertHoldEvent(ctx, tx, hold.Id, HoldActionPlaced, note, userId)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	hold.Active = !hold.StartsAt.After(time.Now())
+	hold.Events = []*HoldEvent{event}
+
+	return nil
+}
+
+func (m HoldModel) Get(id int64, accountId int64, bankId int64) (*Hold, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+        SELECT holds.id, holds.account_id, holds.kind, holds.amount_in_cents, holds.reason_code, holds.authority, holds.starts_at, holds.expires_at, holds.released_at, %s, holds.created_at, holds.version
+        FROM holds
+        INNER JOIN accounts ON holds.account_id = accounts.id
+        WHERE holds.id = $1 AND holds.account_id = $2 AND accounts.bank_id = $3`, activeHold)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hold, err := scanHold(m.ReadDb.QueryRowContext(ctx, query, id, accountId, bankId))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+        SELECT id, action, note, COALESCE(user_id, 0), created_at
+        FROM hold_events
+        WHERE hold_id = $1
+        ORDER BY id`
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, hold.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	hold.Events = []*HoldEvent{}
+
+	for rows.Next() {
+		var event HoldEvent
+
+		err := rows.Scan(&event.Id, &event.Action, &event.Note, &event.UserId, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		hold.Events = append(hold.Events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// Release lifts hold on behalf of userId, recording note against it. Holds that
+// have already been released or have expired can't be released.
+func (m HoldModel) Release(hold *Hold, note string, userId int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE holds
+        SET released_at = now(), version = version + 1
+        WHERE id = $1 AND version = $2 AND released_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+        RETURNING released_at, version`
+
+	err = tx.QueryRowContext(ctx, query, hold.Id, hold.Version).Scan(&hold.ReleasedAt, &hold.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrHoldNotActive
+		default:
+			return err
+		}
+	}
+
+	event, err := insertHoldEvent(ctx, tx, hold.Id, HoldActionReleased, note, userId)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	hold.Active = false
+	hold.Events = append(hold.Events, event)
+
+	return nil
+}
+
+func (m HoldModel) GetAll(accountId int64, bankId int64, activeOnly bool, filters Filters) ([]*Hold, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), holds.id, holds.account_id, holds.kind, holds.amount_in_cents, holds.reason_code, holds.authority, holds.starts_at, holds.expires_at, holds.released_at, %s, holds.created_at, holds.version
+        FROM holds
+        INNER JOIN accounts ON holds.account_id = accounts.id
+        WHERE holds.account_id = $1 AND accounts.bank_id = $2
+        AND (NOT $3::boolean OR (%s))
+        ORDER BY holds.%s %s, holds.id ASC
+        LIMIT $4 OFFSET $5`, activeHold, activeHold, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, accountId, bankId, activeOnly, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	holds := []*Hold{}
+
+	for rows.Next() {
+		var hold Hold
+
+		err := rows.Scan(
+			&totalRecords,
+			&hold.Id,
+			&hold.AccountId,
+			&hold.Kind,
+			&hold.AmountInCents,
+			&hold.ReasonCode,
+			&hold.Authority,
+			&hold.StartsAt,
+			&hold.ExpiresAt,
+			&hold.ReleasedAt,
+			&hold.Active,
+			&hold.CreatedAt,
+			&hold.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		holds = append(holds, &hold)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return holds, metadata, nil
+}
+
+func scanHold(row *sql.Row) (*Hold, error) {
+	var hold Hold
+
+	err := row.Scan(
+		&hold.Id,
+		&hold.AccountId,
+		&hold.Kind,
+		&hold.AmountInCents,
+		&hold.ReasonCode,
+		&hold.Authority,
+		&hold.StartsAt,
+		&hold.ExpiresAt,
+		&hold.ReleasedAt,
+		&hold.Active,
+		&hold.CreatedAt,
+		&hold.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hold, nil
+}
+
+func insertHoldEvent(ctx context.Context, tx *sql.Tx, holdId int64, action string, note string, userId int64) (*HoldEvent, error) {
+	event := &HoldEvent{Action: action, Note: note, UserId: userId}
+
+	query := `
+        INSERT INTO hold_events (hold_id, action, note, user_id)
+        VALUES ($1, $2, $3, NULLIF($4::bigint, 0))
+        RETURNING id, created_at`
+
+	err := tx.QueryRowContext(ctx, query, holdId, action, note, userId).Scan(&event.Id, &event.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// checkHolds stops a debit that would take the account below the total of its
+// amount holds and pending authorizations, or any debit at all while it is
+// frozen.
+func checkHolds(ctx context.Context, tx *sql.Tx, accountId int64, balanceInCents int64) error {
+	query := fmt.Sprintf(`
+        SELECT %s, %s
+        FROM accounts
+        WHERE accounts.id = $1`, accountStatusColumn, accountHeldColumn)
+
+	var status string
+	var heldInCents int64
+
+	err := tx.QueryRowContext(ctx, query, accountId).Scan(&status, &heldInCents)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status == AccountStatusFrozen:
+		return ErrAccountFrozen
+	case balanceInCents < heldInCents:
+		return ErrInsufficientFunds
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+// An IdempotencyKey records the first response a bank received for a given
+// Idempotency-Key header, so retries of the same request can be answered with
+// it instead of being executed again. Response fields are empty while the
+// first request is still in flight.
+type IdempotencyKey struct {
+	BankId          int64
+	Key             string
+	RequestMethod   string
+	RequestPath     string
+	RequestHash     []byte
+	ResponseStatus  int
+	ResponseHeaders map[string][]string
+	ResponseBody    []byte
+	CreatedAt       time.Time
+}
+
+func (k *IdempotencyKey) Completed() bool {
+	return k.ResponseStatus != 0
+}
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(key != "", "Idempotency-Key", "must be provided")
+	v.Check(utf8.RuneCountInString(key) <= 255, "Idempotency-Key", "must not be more than 255 characters long")
+}
+
+type IdempotencyKeyModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+// Claim reserves key for a new request. It reports false if the key is
+// already held by a request made within ttl, in which case the caller should
+// look the key up and replay or reject. Expired keys are reclaimed.
+func (m IdempotencyKeyModel) Claim(key *IdempotencyKey, ttl time.Duration) (bool, error) {
+	query := `
+        INSERT INTO idempotency_keys (bank_id, key, request_method, request_path, request_hash)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (bank_id, key) DO UPDATE
+        SET request_method = EXCLUDED.request_method,
+            request_path = EXCLUDED.request_path,
+            request_hash = EXCLUDED.request_hash,
+            response_status = NULL,
+            response_headers = NULL,
+            response_body = NULL,
+            created_at = now()
+        WHERE idempotency_keys.created_at < $6
+        RETURNING created_at`
+
+	args := []interface{}{key.BankId, key.Key, key.RequestMethod, key.RequestPath, key.RequestHash, time.Now().Add(-ttl)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&key.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (m IdempotencyKeyModel) Get(bankId int64, key string) (*IdempotencyKey, error) {
+	query := `
+        SELECT bank_id, key, request_method, request_path, request_hash, COALESCE(response_status, 0), response_headers, response_body, created_at
+        FROM idempotency_keys
+        WHERE bank_id = $1 AND key = $2`
+
+	var idempotencyKey IdempotencyKey
+	var headers []byte
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.WriteDb.QueryRowContext(ctx, query, bankId, key).Scan(
+		&idempotencyKey.BankId,
+		&idempotencyKey.Key,
+		&idempotencyKey.RequestMethod,
+		&idempotencyKey.RequestPath,
+		&idempotencyKey.RequestHash,
+		&idempotencyKey.ResponseStatus,
+		&headers,
+		&idempotencyKey.ResponseBody,
+		&idempotencyKey.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if headers != nil {
+		err = json.Unmarshal(headers, &idempotencyKey.ResponseHeaders)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &idempotencyKey, nil
+}
+
+func (m IdempotencyKeyModel) Complete(key *IdempotencyKey) error {
+	headers, err := json.Marshal(key.ResponseHeaders)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE idempotency_keys
+        SET response_status = $1, response_headers = $2, response_body = $3
+        WHERE bank_id = $4 AND key = $5`
+
+	args := []interface{}{key.ResponseStatus, headers, key.ResponseBody, key.BankId, key.Key}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.WriteDb.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m IdempotencyKeyModel) Delete(bankId int64, key string) error {
+	query := `
+        DELETE FROM idempotency_keys
+        WHERE bank_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.WriteDb.ExecContext(ctx, query, bankId, key)
+	return err
+}
+
+func (m IdempotencyKeyModel) DeleteExpired(ttl time.Duration) error {
+	query := `
+        DELETE FROM idempotency_keys
+        WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := m.WriteDb.ExecContext(ctx, query, time.Now().Add(-ttl))
+	return err
+}
@@ -0,0 +1,198 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+var (
+	ErrKycBalanceLimitExceeded     = errors.New("kyc balance limit exceeded")
+	ErrKycTransactionLimitExceeded = errors.New("kyc transaction limit exceeded")
+)
+
+const (
+	KycTierAnonymous = "anonymous"
+	KycTierBasic     = "basic"
+	KycTierFull      = "full"
+)
+
+var KycTiers = []string{KycTierAnonymous, KycTierBasic, KycTierFull}
+
+// A KycTier caps how much an account can hold and how much it can send in a
+// single movement. A limit of 0 means unlimited. An account takes the lowest
+// tier among its depositors. Accounts without depositors, such as a bank's
+// own settlement or sweep accounts, aren't held to any tier.
+type KycTier struct {
+	Tier                  string `json:"tier"`
+	Rank                  int    `json:"rank"`
+	MaxBalanceInCents     int64  `json:"max_balance_in_cents"`
+	MaxTransactionInCents int64  `json:"max_transaction_in_cents"`
+	Version               int64  `json:"version"`
+}
+
+func ValidateKycTier(v *validator.Validator, tier *KycTier) {
+	v.Check(tier.MaxBalanceInCents >= 0, "max_balance_in_cents", "must not be negative")
+	v.Check(tier.MaxTransactionInCents >= 0, "max_transaction_in_cents", "must not be negative")
+}
+
+// accountKycTierColumn is the tier an account is held to, or an empty string
+// if it has no depositors. It expects the accounts table to be in scope.
+const accountKycTierColumn = `
+        COALESCE((
+            SELECT kyc_tiers.tier
+            FROM account_depositors
+            INNER JOIN depositors ON account_depositors.depositor_id = depositors.id
+            INNER JOIN kyc_tiers ON depositors.kyc_tier = kyc_tiers.tier
+            WHERE account_depositors.account_id = accounts.id
+            ORDER BY kyc_tiers.rank
+            LIMIT 1
+        ), '')`
+
+type KycTierModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+func (m KycTierModel) Get(tier string) (*KycTier, error) {
+	query := `
+        SELECT tier, rank, max_balance_in_cents, max_transaction_in_cents, version
+        FROM kyc_tiers
+        WHERE tier = $1`
+
+	var kycTier KycTier
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.ReadDb.QueryRowContext(ctx, query, tier).Scan(
+		&kycTier.Tier,
+		&kycTier.Rank,
+		&kycTier.MaxBalanceInCents,
+		&kycTier.MaxTransactionInCents,
+		&kycTier.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &kycTier, nil
+}
+
+func (m KycTierModel) GetAll() ([]*KycTier, error) {
+	query := `
+        SELECT tier, rank, max_balance_in_cents, max_transaction_in_cents, version
+        FROM kyc_tiers
+        ORDER BY rank`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tiers := []*KycTier{}
+
+	for rows.Next() {
+		var tier KycTier
+
+		err := rows.Scan(
+			&tier.Tier,
+			&tier.Rank,
+			&tier.MaxBalanceInCents,
+			&tier.MaxTransactionInCents,
+			&tier.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tiers = append(tiers, &tier)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tiers, nil
+}
+
+func (m KycTierModel) Update(tier *KycTier) error {
+	query := `
+        UPDATE kyc_tiers
+        SET max_balance_in_cents = $1, max_transaction_in_cents = $2, version = version + 1
+        WHERE tier = $3 AND version = $4
+        RETURNING version`
+
+	args := []interface{}{tier.MaxBalanceInCents, tier.MaxTransactionInCents, tier.Tier, tier.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&tier.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkKycLimits enforces the account's tier after amountInCents has been
+// applied, leaving it with balanceInCents. Debits are held to the transaction
+// limit and credits to the balance limit. Accounts without a tier pass.
+func checkKycLimits(ctx context.Context, tx *sql.Tx, accountId int64, amountInCents int64, balanceInCents int64) error {
+	query := fmt.Sprintf(`
+        SELECT kyc_tiers.max_balance_in_cents, kyc_tiers.max_transaction_in_cents
+        FROM accounts
+        INNER JOIN kyc_tiers ON kyc_tiers.tier = %s
+        WHERE accounts.id = $1`, accountKycTierColumn)
+
+	var tier KycTier
+
+	err := tx.QueryRowContext(ctx, query, accountId).Scan(&tier.MaxBalanceInCents, &tier.MaxTransactionInCents)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	switch {
+	case amountInCents < 0 && tier.MaxTransactionInCents != 0 && -amountInCents > tier.MaxTransactionInCents:
+		return ErrKycTransactionLimitExceeded
+	case amountInCents > 0 && tier.MaxBalanceInCents != 0 && balanceInCents > tier.MaxBalanceInCents:
+		return ErrKycBalanceLimitExceeded
+	}
+
+	return nil
+}
+
+func accountKycTier(ctx context.Context, tx *sql.Tx, accountId int64) (string, error) {
+	query := fmt.Sprintf(`SELECT %s FROM accounts WHERE id = $1`, accountKycTierColumn)
+
+	var tier string
+
+	err := tx.QueryRowContext(ctx, query, accountId).Scan(&tier)
+	return tier, err
+}
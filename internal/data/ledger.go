@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrUnbalancedPosting = errors.New("unbalanced posting")
+)
+
+const (
+	PostingKindTransfer = "transfer"
+	PostingKindCashIn   = "cash_in"
+	PostingKindCashOut  = "cash_out"
+	PostingKindRefund   = "refund"
+
+	// PostingKindOpeningBalance postings carry balances from before the
+	// ledger, against an entry with neither a bank nor an account.
+	PostingKindOpeningBalance = "opening_balance"
+)
+
+// A Posting groups the ledger entries of a single movement of money. Entries
+// credit (positive) or debit (negative) either an account or a bank's reserve
+// balance, and the entries of every posting sum to zero.
+type Posting struct {
+	Id        int64          `json:"id"`
+	Kind      string         `json:"kind"`
+	Entries   []*LedgerEntry `json:"entries"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type LedgerEntry struct {
+	Id            int64     `json:"id"`
+	PostingId     int64     `json:"posting_id"`
+	PostingKind   string    `json:"posting_kind,omitempty"`
+	BankId        int64     `json:"bank_id,omitempty"`
+	AccountId     int64     `json:"account_id,omitempty"`
+	AmountInCents int64     `json:"amount_in_cents"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// post appends posting to the journal inside tx and applies each entry to the
+// cached balance of the account or bank it touches. Callers are responsible
+// for locking the affected rows and enforcing their own balance rules first;
+// holds and the holding and KYC limits of every account touched are enforced
+// here.
+func post(ctx context.Context, tx *sql.Tx, posting *Posting) error {
+	var sum int64
+
+	for _, entry := range posting.Entries {
+		if entry.AmountInCents == 0 || (entry.BankId == 0) == (entry.AccountId == 0) {
+			return ErrUnbalancedPosting
+		}
+
+		sum += entry.AmountInCents
+	}
+
+	if len(posting.Entries) < 2 || sum != 0 {
+		return ErrUnbalancedPosting
+	}
+
+	query := `
+        INSERT INTO postings (kind)
+        VALUES ($1)
+        RETURNING id, created_at`
+
+	err := tx.QueryRowContext(ctx, query, posting.Kind).Scan(&posting.Id, &posting.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range posting.Entries {
+		entry.PostingId = posting.Id
+		entry.PostingKind = posting.Kind
+
+		query = `
+            INSERT INTO ledger_entries (posting_id, bank_id, account_id, amount_in_cents)
+            VALUES ($1, NULLIF($2::bigint, 0), NULLIF($3::bigint, 0), $4)
+            RETURNING id, created_at`
+
+		args := []interface{}{entry.PostingId, entry.BankId, entry.AccountId, entry.AmountInCents}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&entry.Id, &entry.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		if entry.AccountId != 0 {
+			query = `
+                UPDATE accounts
+                SET balance_in_cents = balance_in_cents + $1, version = version + 1
+                WHERE id = $2
+                RETURNING balance_in_cents, holding_limit_in_cents`
+
+			var balanceInCents, holdingLimitInCents int64
+
+			err = tx.QueryRowContext(ctx, query, entry.AmountInCents, entry.AccountId).Scan(&balanceInCents, &holdingLimitInCents)
+			if err != nil {
+				return err
+			}
+
+			if entry.AmountInCents > 0 && holdingLimitInCents != 0 && balanceInCents > holdingLimitInCents {
+				return ErrHoldingLimitExceeded
+			}
+
+			if entry.AmountInCents < 0 {
+				err = checkHolds(ctx, tx, entry.AccountId, balanceInCents)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = checkKycLimits(ctx, tx, entry.AccountId, entry.AmountInCents, balanceInCents)
+		} else {
+			query = `
+                UPDATE banks
+                SET balance_in_cents = balance_in_cents + $1, version = version + 1
+                WHERE id = $2`
+
+			_, err = tx.ExecContext(ctx, query, entry.AmountInCents, entry.BankId)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type LedgerModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+func (m LedgerModel) GetAllForAccount(accountId int64, bankId int64, filters Filters) ([]*LedgerEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), ledger_entries.id, ledger_entries.posting_id, postings.kind, COALESCE(ledger_entries.bank_id, 0), COALESCE(ledger_entries.account_id, 0), ledger_entries.amount_in_cents, ledger_entries.created_at
+        FROM ledger_entries
+        INNER JOIN postings ON ledger_entries.posting_id = postings.id
+        INNER JOIN accounts ON ledger_entries.account_id = accounts.id
+        WHERE accounts.id = $1 AND accounts.bank_id = $2
+        ORDER BY ledger_entries.%s %s, ledger_entries.id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{accountId, bankId, filters.limit(), filters.offset()}
+
+	return m.getAll(query, args, filters)
+}
+
+func (m LedgerModel) GetAllForBank(bankId int64, filters Filters) ([]*LedgerEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), ledger_entries.id, ledger_entries.posting_id, postings.kind, COALESCE(ledger_entries.bank_id, 0), COALESCE(ledger_entries.account_id, 0), ledger_entries.amount_in_cents, ledger_entries.created_at
+        FROM ledger_entries
+        INNER JOIN postings ON ledger_entries.posting_id = postings.id
+        WHERE ledger_entries.bank_id = $1
+        ORDER BY ledger_entries.%s %s, ledger_entries.id ASC
+        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{bankId, filters.limit(), filters.offset()}
+
+	return m.getAll(query, args, filters)
+}
+
+func (m LedgerModel) getAll(query string, args []interface{}, filters Filters) ([]*LedgerEntry, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*LedgerEntry{}
+
+	for rows.Next() {
+		var entry LedgerEntry
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.Id,
+			&entry.PostingId,
+			&entry.PostingKind,
+			&entry.BankId,
+			&entry.AccountId,
+			&entry.AmountInCents,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
@@ -11,17 +11,43 @@ var (
 )
 
 type Models struct {
-	Tokens   TokenModel
-	Banks    BankModel
-	Accounts AccountModel
-	Cards    CardModel
+	Tokens             TokenModel
+	Banks              BankModel
+	Users              UserModel
+	AuditEvents        AuditEventModel
+	Accounts           AccountModel
+	Depositors         DepositorModel
+	Holds              HoldModel
+	KycTiers           KycTierModel
+	Cards              CardModel
+	Transfers          TransferModel
+	ScheduledTransfers ScheduledTransferModel
+	Ledger             LedgerModel
+	Payments           PaymentModel
+	Authorizations     AuthorizationModel
+	Bins               BinModel
+	IdempotencyKeys    IdempotencyKeyModel
+	Reserves           ReserveModel
 }
 
 func NewModels(writeDb *sql.DB, readDb *sql.DB) Models {
 	return Models{
-		Tokens:   TokenModel{WriteDb: writeDb, ReadDb: readDb},
-		Banks:    BankModel{WriteDb: writeDb, ReadDb: readDb},
-		Accounts: AccountModel{WriteDb: writeDb, ReadDb: readDb},
-		Cards:    CardModel{WriteDb: writeDb, ReadDb: readDb},
+		Tokens:             TokenModel{WriteDb: writeDb, ReadDb: readDb},
+		Banks:              BankModel{WriteDb: writeDb, ReadDb: readDb},
+		Users:              UserModel{WriteDb: writeDb, ReadDb: readDb},
+		AuditEvents:        AuditEventModel{WriteDb: writeDb, ReadDb: readDb},
+		Accounts:           AccountModel{WriteDb: writeDb, ReadDb: readDb},
+		Depositors:         DepositorModel{WriteDb: writeDb, ReadDb: readDb},
+		Holds:              HoldModel{WriteDb: writeDb, ReadDb: readDb},
+		KycTiers:           KycTierModel{WriteDb: writeDb, ReadDb: readDb},
+		Cards:              CardModel{WriteDb: writeDb, ReadDb: readDb},
+		Transfers:          TransferModel{WriteDb: writeDb, ReadDb: readDb},
+		ScheduledTransfers: ScheduledTransferModel{WriteDb: writeDb, ReadDb: readDb},
+		Ledger:             LedgerModel{WriteDb: writeDb, ReadDb: readDb},
+		Payments:           PaymentModel{WriteDb: writeDb, ReadDb: readDb},
+		Authorizations:     AuthorizationModel{WriteDb: writeDb, ReadDb: readDb},
+		Bins:               BinModel{WriteDb: writeDb, ReadDb: readDb},
+		IdempotencyKeys:    IdempotencyKeyModel{WriteDb: writeDb, ReadDb: readDb},
+		Reserves:           ReserveModel{WriteDb: writeDb, ReadDb: readDb},
 	}
 }
@@ -0,0 +1,456 @@
+package data
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+var (
+	ErrCardNotFound      = errors.New("card not found")
+	ErrCardExpired       = errors.New("card expired")
+	ErrPaymentNotPending = errors.New("payment not pending")
+	ErrPaymentExpired    = errors.New("payment expired")
+	ErrInvalidSignature  = errors.New("invalid signature")
+)
+
+const (
+	PaymentStatusPending    = "pending"
+	PaymentStatusCompleted  = "completed"
+	PaymentStatusAuthorized = "authorized"
+	PaymentStatusExpired    = "expired"
+)
+
+// The policies for payments and transfers into the accounts of a frozen bank.
+// Frozen banks can't move money out either way.
+const (
+	FrozenBankPaymentsAccept = "accept"
+	FrozenBankPaymentsReject = "reject"
+)
+
+// A Payment with Capture unset only authorizes its amount when confirmed,
+// leaving the merchant to capture or void the resulting Authorization.
+type Payment struct {
+	Id              int64     `json:"id"`
+	BankId          int64     `json:"-"`
+	CardId          int64     `json:"card_id"`
+	TargetAccountId int64     `json:"target_account_id"`
+	AmountInCents   int64     `json:"amount_in_cents"`
+	Capture         bool      `json:"capture"`
+	Challenge       []byte    `json:"challenge"`
+	Status          string    `json:"status"`
+	TransferId      int64     `json:"transfer_id,omitempty"`
+	AuthorizationId int64     `json:"authorization_id,omitempty"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	Version         int64     `json:"version"`
+}
+
+func ValidatePayment(v *validator.Validator, payment *Payment) {
+	v.Check(payment.CardId != 0, "card_id", "must be provided")
+	v.Check(payment.CardId > 0, "card_id", "must be greater than 0")
+	v.Check(payment.TargetAccountId != 0, "target_account_id", "must be provided")
+	v.Check(payment.TargetAccountId > 0, "target_account_id", "must be greater than 0")
+	v.Check(payment.AmountInCents > 0, "amount_in_cents", "must be greater than 0")
+}
+
+func ValidateSignature(v *validator.Validator, signature []byte) {
+	v.Check(len(signature) != 0, "signature", "must be provided")
+	v.Check(len(signature) == ed25519.SignatureSize, "signature", "must be 64 bytes long")
+}
+
+type PaymentModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+// New issues a fresh single-use challenge for payment and stores it. The card
+// must sign the challenge before ttl elapses for the payment to be confirmed.
+// Payments into a frozen bank's accounts are turned away here already if
+// frozenBankPayments rejects them.
+func (m PaymentModel) New(payment *Payment, ttl time.Duration, frozenBankPayments string) error {
+	payment.Challenge = make([]byte, 32)
+
+	_, err := rand.Read(payment.Challenge)
+	if err != nil {
+		return err
+	}
+
+	payment.Status = PaymentStatusPending
+	payment.ExpiresAt = time.Now().Add(ttl)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = checkPaymentTarget(ctx, m.ReadDb, payment.TargetAccountId, frozenBankPayments)
+	if err != nil {
+		return err
+	}
+
+	var card Card
+
+	err = m.ReadDb.QueryRowContext(ctx, `SELECT expiry, status, locked FROM cards WHERE id = $1`, payment.CardId).Scan(&card.Expiry, &card.Status, &card.Locked)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrCardNotFound
+		default:
+			return err
+		}
+	}
+
+	if card.Status != CardStatusActive {
+		return ErrCardInactive
+	}
+
+	if card.Locked {
+		return ErrCardLocked
+	}
+
+	if time.Now().After(card.Expiry) {
+		return ErrCardExpired
+	}
+
+	query := `
+        INSERT INTO payments (bank_id, card_id, target_account_id, amount_in_cents, capture, challenge, expires_at)
+        SELECT $1, $2, id, $4, $5, $6, $7 FROM accounts WHERE id = $3
+        RETURNING id, created_at, version`
+
+	args := []interface{}{
+		payment.BankId,
+		payment.CardId,
+		payment.TargetAccountId,
+		payment.AmountInCents,
+		payment.Capture,
+		payment.Challenge,
+		payment.ExpiresAt,
+	}
+
+	err = m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&payment.Id, &payment.CreatedAt, &payment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrTargetAccountNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m PaymentModel) Get(id int64, bankId int64) (*Payment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, bank_id, card_id, target_account_id, amount_in_cents, capture, challenge, status, COALESCE(transfer_id, 0), COALESCE(authorization_id, 0), expires_at, created_at, version
+        FROM payments
+        WHERE id = $1 AND bank_id = $2`
+
+	var payment Payment
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.ReadDb.QueryRowContext(ctx, query, id, bankId).Scan(
+		&payment.Id,
+		&payment.BankId,
+		&payment.CardId,
+		&payment.TargetAccountId,
+		&payment.AmountInCents,
+		&payment.Capture,
+		&payment.Challenge,
+		&payment.Status,
+		&payment.TransferId,
+		&payment.AuthorizationId,
+		&payment.ExpiresAt,
+		&payment.CreatedAt,
+		&payment.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &payment, nil
+}
+
+// Confirm completes a pending payment once the card has signed its challenge
+// and the cardholder has entered the right PIN. The payment row is locked for
+// the duration of the transaction, so a challenge can only ever be redeemed
+// once. Consecutive wrong PINs are counted on the card, which locks after
+// maxPinAttempts of them. Payments that don't capture are authorized instead,
+// reserving their amount for authorizationTTL. frozenBankPayments decides
+// whether a payment into a frozen bank's account goes through.
+func (m PaymentModel) Confirm(payment *Payment, signature []byte, pin string, maxPinAttempts int, authorizationTTL time.Duration, frozenBankPayments string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT card_id, target_account_id, amount_in_cents, capture, challenge, status, expires_at, created_at, version
+        FROM payments
+        WHERE id = $1 AND bank_id = $2
+        FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, payment.Id, payment.BankId).Scan(
+		&payment.CardId,
+		&payment.TargetAccountId,
+		&payment.AmountInCents,
+		&payment.Capture,
+		&payment.Challenge,
+		&payment.Status,
+		&payment.ExpiresAt,
+		&payment.CreatedAt,
+		&payment.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if payment.Status != PaymentStatusPending {
+		return ErrPaymentNotPending
+	}
+
+	if time.Now().After(payment.ExpiresAt) {
+		query = `
+            UPDATE payments
+            SET status = $1, version = version + 1
+            WHERE id = $2`
+
+		_, err = tx.ExecContext(ctx, query, PaymentStatusExpired, payment.Id)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+
+		payment.Status = PaymentStatusExpired
+		return ErrPaymentExpired
+	}
+
+	var card Card
+	var bankId int64
+
+	query = `
+        SELECT cards.id, cards.account_id, cards.public_key, cards.password_hash, cards.expiry, cards.status, cards.failed_pin_attempts, cards.locked, accounts.bank_id
+        FROM cards
+        INNER JOIN accounts ON cards.account_id = accounts.id
+        WHERE cards.id = $1
+        FOR UPDATE OF cards`
+
+	err = tx.QueryRowContext(ctx, query, payment.CardId).Scan(
+		&card.Id,
+		&card.AccountId,
+		&card.PublicKey,
+		&card.Password.hash,
+		&card.Expiry,
+		&card.Status,
+		&card.FailedPinAttempts,
+		&card.Locked,
+		&bankId,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrCardNotFound
+		default:
+			return err
+		}
+	}
+
+	if card.Status != CardStatusActive {
+		return ErrCardInactive
+	}
+
+	if time.Now().After(card.Expiry) {
+		return ErrCardExpired
+	}
+
+	if card.Locked {
+		return ErrCardLocked
+	}
+
+	if !card.Verify(payment.Challenge, signature) {
+		return ErrInvalidSignature
+	}
+
+	match, err := card.Password.Matches(pin)
+	if err != nil {
+		return err
+	}
+
+	if !match {
+		card.FailedPinAttempts++
+		card.Locked = card.FailedPinAttempts >= maxPinAttempts
+
+		err = setPinAttempts(ctx, tx, &card)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+
+		if card.Locked {
+			return ErrCardLocked
+		}
+		return ErrIncorrectPin
+	}
+
+	if card.FailedPinAttempts > 0 {
+		card.FailedPinAttempts = 0
+
+		err = setPinAttempts(ctx, tx, &card)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkPaymentTarget(ctx, tx, payment.TargetAccountId, frozenBankPayments)
+	if err != nil {
+		return err
+	}
+
+	if !payment.Capture {
+		authorization := &Authorization{
+			BankId:          payment.BankId,
+			CardId:          card.Id,
+			AccountId:       card.AccountId,
+			TargetAccountId: payment.TargetAccountId,
+			AmountInCents:   payment.AmountInCents,
+			ExpiresAt:       time.Now().Add(authorizationTTL),
+		}
+
+		err = insertAuthorization(ctx, tx, authorization)
+		if err != nil {
+			return err
+		}
+
+		query = `
+            UPDATE payments
+            SET status = $1, authorization_id = $2, version = version + 1
+            WHERE id = $3
+            RETURNING version`
+
+		err = tx.QueryRowContext(ctx, query, PaymentStatusAuthorized, authorization.Id, payment.Id).Scan(&payment.Version)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+
+		payment.Status = PaymentStatusAuthorized
+		payment.AuthorizationId = authorization.Id
+
+		return nil
+	}
+
+	transfer := &Transfer{
+		SourceAccountId: card.AccountId,
+		TargetAccountId: payment.TargetAccountId,
+		AmountInCents:   payment.AmountInCents,
+	}
+
+	err = insertTransfer(ctx, tx, transfer, bankId, frozenBankPayments)
+	if err != nil {
+		return err
+	}
+
+	query = `
+        UPDATE payments
+        SET status = $1, transfer_id = $2, version = version + 1
+        WHERE id = $3
+        RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, PaymentStatusCompleted, transfer.Id, payment.Id).Scan(&payment.Version)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	payment.Status = PaymentStatusCompleted
+	payment.TransferId = transfer.Id
+
+	return nil
+}
+
+func setPinAttempts(ctx context.Context, tx *sql.Tx, card *Card) error {
+	query := `
+        UPDATE cards
+        SET failed_pin_attempts = $1, locked = $2, version = version + 1
+        WHERE id = $3`
+
+	_, err := tx.ExecContext(ctx, query, card.FailedPinAttempts, card.Locked, card.Id)
+	return err
+}
+
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// checkPaymentTarget turns away a card payment into accountId while its bank
+// is frozen, unless frozenBankPayments accepts them.
+func checkPaymentTarget(ctx context.Context, q rowQuerier, accountId int64, frozenBankPayments string) error {
+	if frozenBankPayments != FrozenBankPaymentsReject {
+		return nil
+	}
+
+	query := `
+        SELECT banks.frozen
+        FROM accounts
+        INNER JOIN banks ON accounts.bank_id = banks.id
+        WHERE accounts.id = $1`
+
+	var frozen bool
+
+	err := q.QueryRowContext(ctx, query, accountId).Scan(&frozen)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrTargetAccountNotFound
+		default:
+			return err
+		}
+	}
+
+	if frozen {
+		return ErrTargetBankFrozen
+	}
+
+	return nil
+}
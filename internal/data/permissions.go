@@ -0,0 +1,53 @@
+package data
+
+const (
+	RoleCentralBank    = "central_bank"
+	RoleCommercialBank = "commercial_bank"
+	RolePsp            = "psp"
+)
+
+var Roles = []string{RoleCentralBank, RoleCommercialBank, RolePsp}
+
+const (
+	// PermissionMonetary covers issuing and redeeming reserves and setting the
+	// KYC limits every bank is held to.
+	PermissionMonetary = "monetary"
+	// PermissionSupervision covers looking into other banks' affairs and
+	// onboarding, editing and freezing them.
+	PermissionSupervision = "supervision"
+	// PermissionCustomers covers a bank's own depositors, accounts, cards and
+	// the money they move.
+	PermissionCustomers = "customers"
+	// PermissionPayments covers starting and settling card payments.
+	PermissionPayments = "payments"
+)
+
+// rolePermissions is what each role may do. Central bank operators supervise
+// and run monetary policy, commercial banks serve their own customers, and
+// payment service providers only take card payments.
+var rolePermissions = map[string][]string{
+	RoleCentralBank:    {PermissionMonetary, PermissionSupervision},
+	RoleCommercialBank: {PermissionCustomers, PermissionPayments},
+	RolePsp:            {PermissionPayments},
+}
+
+// permissionsFor lists what role allows a bank to do.
+func permissionsFor(role string) []string {
+	permissions := rolePermissions[role]
+	if permissions == nil {
+		return []string{}
+	}
+
+	return permissions
+}
+
+// Can reports whether the bank's role grants permission.
+func (u *Bank) Can(permission string) bool {
+	for _, p := range rolePermissions[u.Role] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+var (
+	ErrCentralBankNotFound  = errors.New("central bank not found")
+	ErrInsufficientReserves = errors.New("insufficient reserves")
+)
+
+const (
+	PostingKindIssuance   = "issuance"
+	PostingKindRedemption = "redemption"
+)
+
+const (
+	ReserveOperationIssuance   = "issuance"
+	ReserveOperationRedemption = "redemption"
+)
+
+// A ReserveOperation records the central bank minting money into a bank's
+// reserve balance (issuance) or burning it back out (redemption). The central
+// bank's own balance is the negative of all reserves it has issued, so the
+// money supply always nets to zero across the ledger. UserId is 0 for
+// operations made with the bank's own credentials.
+type ReserveOperation struct {
+	Id            int64     `json:"id"`
+	BankId        int64     `json:"bank_id"`
+	Kind          string    `json:"kind"`
+	AmountInCents int64     `json:"amount_in_cents"`
+	Operator      string    `json:"operator"`
+	UserId        int64     `json:"user_id,omitempty"`
+	Reason        string    `json:"reason"`
+	Reference     string    `json:"reference"`
+	PostingId     int64     `json:"posting_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func ValidateReserveOperation(v *validator.Validator, op *ReserveOperation) {
+	v.Check(op.BankId != 0, "bank_id", "must be provided")
+	v.Check(op.BankId > 0, "bank_id", "must be greater than 0")
+	v.Check(op.AmountInCents > 0, "amount_in_cents", "must be greater than 0")
+	v.Check(op.Operator != "", "operator", "must be provided")
+	v.Check(utf8.RuneCountInString(op.Operator) <= 500, "operator", "must not be more than 500 characters long")
+	v.Check(op.Reason != "", "reason", "must be provided")
+	v.Check(utf8.RuneCountInString(op.Reason) <= 500, "reason", "must not be more than 500 characters long")
+	v.Check(op.Reference != "", "reference", "must be provided")
+	v.Check(utf8.RuneCountInString(op.Reference) <= 500, "reference", "must not be more than 500 characters long")
+}
+
+type ReserveModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+// Insert applies op to the reserve balance of op.BankId, moving the amount from
+// or to the central bank. Redemptions may not take a bank's reserve below zero.
+func (m ReserveModel) Insert(op *ReserveOperation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT id, balance_in_cents, role
+        FROM banks
+        WHERE id = $1 OR role = 'central_bank'
+        ORDER BY id
+        FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, op.BankId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var central, bank *Bank
+
+	for rows.Next() {
+		var b Bank
+
+		err := rows.Scan(&b.Id, &b.BalanceInCents, &b.Role)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case b.Role == RoleCentralBank:
+			central = &b
+		case b.Id == op.BankId:
+			bank = &b
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	switch {
+	case central == nil:
+		return ErrCentralBankNotFound
+	case bank == nil:
+		return ErrRecordNotFound
+	case op.Kind == ReserveOperationRedemption && bank.BalanceInCents < op.AmountInCents:
+		return ErrInsufficientReserves
+	}
+
+	amount := op.AmountInCents
+	kind := PostingKindIssuance
+	if op.Kind == ReserveOperationRedemption {
+		amount = -amount
+		kind = PostingKindRedemption
+	}
+
+	posting := &Posting{
+		Kind: kind,
+		Entries: []*LedgerEntry{
+			{BankId: central.Id, AmountInCents: -amount},
+			{BankId: bank.Id, AmountInCents: amount},
+		},
+	}
+
+	err = post(ctx, tx, posting)
+	if err != nil {
+		return err
+	}
+
+	op.PostingId = posting.Id
+
+	query = `
+        INSERT INTO reserve_operations (bank_id, kind, amount_in_cents, operator, user_id, reason, reference, posting_id)
+        VALUES ($1, $2, $3, $4, NULLIF($5::bigint, 0), $6, $7, $8)
+        RETURNING id, created_at`
+
+	args := []interface{}{op.BankId, op.Kind, op.AmountInCents, op.Operator, op.UserId, op.Reason, op.Reference, op.PostingId}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&op.Id, &op.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ReserveModel) GetAll(bankId int64, kind string, filters Filters) ([]*ReserveOperation, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, bank_id, kind, amount_in_cents, operator, COALESCE(user_id, 0), reason, reference, posting_id, created_at
+        FROM reserve_operations
+        WHERE ($1::bigint = 0 OR bank_id = $1)
+        AND ($2::text = '' OR kind = $2)
+        ORDER BY %s %s, id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, bankId, kind, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	ops := []*ReserveOperation{}
+
+	for rows.Next() {
+		var op ReserveOperation
+
+		err := rows.Scan(
+			&totalRecords,
+			&op.Id,
+			&op.BankId,
+			&op.Kind,
+			&op.AmountInCents,
+			&op.Operator,
+			&op.UserId,
+			&op.Reason,
+			&op.Reference,
+			&op.PostingId,
+			&op.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		ops = append(ops, &op)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return ops, metadata, nil
+}
@@ -0,0 +1,529 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+var (
+	ErrScheduledTransferNotActive = errors.New("scheduled transfer not active")
+)
+
+const (
+	FrequencyOnce    = "once"
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+const (
+	ScheduledTransferStatusActive    = "active"
+	ScheduledTransferStatusCompleted = "completed"
+	ScheduledTransferStatusCancelled = "cancelled"
+)
+
+const (
+	ScheduledRunStatusSucceeded = "succeeded"
+	ScheduledRunStatusRetrying  = "retrying"
+	ScheduledRunStatusFailed    = "failed"
+)
+
+// A ScheduledTransfer repeats a transfer every Every days, weeks or months
+// from StartsAt, or makes it once. It ends after EndsAt or MaxOccurrences
+// occurrences, whichever comes first, 0 and nil meaning never. NextRunAt is
+// when the worker will next attempt it, which is later than the occurrence
+// itself while an attempt that ran out of funds is being retried.
+type ScheduledTransfer struct {
+	Id              int64      `json:"id"`
+	BankId          int64      `json:"-"`
+	SourceAccountId int64      `json:"source_account_id"`
+	TargetAccountId int64      `json:"target_account_id"`
+	AmountInCents   int64      `json:"amount_in_cents"`
+	Description     string     `json:"description"`
+	Frequency       string     `json:"frequency"`
+	Every           int        `json:"every"`
+	StartsAt        time.Time  `json:"starts_at"`
+	EndsAt          *time.Time `json:"ends_at,omitempty"`
+	MaxOccurrences  int        `json:"max_occurrences"`
+	Occurrences     int        `json:"occurrences"`
+	Retries         int        `json:"retries"`
+	NextRunAt       *time.Time `json:"next_run_at,omitempty"`
+	Status          string     `json:"status"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Version         int64      `json:"version"`
+}
+
+// A ScheduledTransferRun records one attempt at an occurrence of a scheduled
+// transfer.
+type ScheduledTransferRun struct {
+	Id                  int64     `json:"id"`
+	ScheduledTransferId int64     `json:"scheduled_transfer_id"`
+	Occurrence          int       `json:"occurrence"`
+	ScheduledFor        time.Time `json:"scheduled_for"`
+	Attempt             int       `json:"attempt"`
+	Status              string    `json:"status"`
+	TransferId          int64     `json:"transfer_id,omitempty"`
+	Error               string    `json:"error,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// transferRefusals are the errors for which insertTransfer refuses a transfer,
+// as opposed to failing to make it.
+var transferRefusals = []error{
+	ErrSourceAccountNotFound,
+	ErrTargetAccountNotFound,
+	ErrAccountFrozen,
+	ErrAccountClosed,
+	ErrInsufficientFunds,
+	ErrKycTransactionLimitExceeded,
+	ErrKycBalanceLimitExceeded,
+	ErrHoldingLimitExceeded,
+	ErrBankFrozen,
+	ErrTargetBankFrozen,
+	ErrInsufficientReserves,
+}
+
+func ValidateScheduledTransfer(v *validator.Validator, st *ScheduledTransfer) {
+	v.Check(st.SourceAccountId != 0, "source_account_id", "must be provided")
+	v.Check(st.SourceAccountId > 0, "source_account_id", "must be greater than 0")
+	v.Check(st.TargetAccountId != 0, "target_account_id", "must be provided")
+	v.Check(st.TargetAccountId > 0, "target_account_id", "must be greater than 0")
+	v.Check(st.SourceAccountId != st.TargetAccountId, "target_account_id", "must be different from source_account_id")
+	v.Check(st.AmountInCents > 0, "amount_in_cents", "must be greater than 0")
+	v.Check(utf8.RuneCountInString(st.Description) <= 500, "description", "must not be more than 500 characters long")
+	v.Check(validator.PermittedValue(st.Frequency, FrequencyOnce, FrequencyDaily, FrequencyWeekly, FrequencyMonthly), "frequency", "must be one of once, daily, weekly or monthly")
+	v.Check(st.Every > 0, "every", "must be greater than 0")
+	v.Check(st.Every <= 366, "every", "must not be more than 366")
+	v.Check(st.MaxOccurrences >= 0, "max_occurrences", "must not be negative")
+	v.Check(st.StartsAt.After(time.Now().Add(-time.Minute)), "starts_at", "must not be in the past")
+
+	if st.EndsAt != nil {
+		v.Check(!st.EndsAt.Before(st.StartsAt), "ends_at", "must not be before starts_at")
+	}
+}
+
+// occurrence returns when the nth occurrence, counting from 0, falls due.
+// Monthly occurrences stay on the day of the month of StartsAt, or the last day
+// of shorter months.
+func (st *ScheduledTransfer) occurrence(n int) time.Time {
+	switch st.Frequency {
+	case FrequencyDaily:
+		return st.StartsAt.AddDate(0, 0, n*st.Every)
+	case FrequencyWeekly:
+		return st.StartsAt.AddDate(0, 0, 7*n*st.Every)
+	case FrequencyMonthly:
+		y, m, d := st.StartsAt.Date()
+		m += time.Month(n * st.Every)
+
+		last := time.Date(y, m+1, 0, 0, 0, 0, 0, st.StartsAt.Location()).Day()
+		if d > last {
+			d = last
+		}
+
+		return time.Date(y, m, d, st.StartsAt.Hour(), st.StartsAt.Minute(), st.StartsAt.Second(), 0, st.StartsAt.Location())
+	}
+
+	return st.StartsAt
+}
+
+// advance moves st on to its next occurrence, completing it if there isn't one.
+func (st *ScheduledTransfer) advance() {
+	st.Occurrences++
+	st.Retries = 0
+
+	next := st.occurrence(st.Occurrences)
+
+	switch {
+	case st.Frequency == FrequencyOnce,
+		st.MaxOccurrences != 0 && st.Occurrences >= st.MaxOccurrences,
+		st.EndsAt != nil && next.After(*st.EndsAt):
+		st.Status = ScheduledTransferStatusCompleted
+		st.NextRunAt = nil
+	default:
+		st.NextRunAt = &next
+	}
+}
+
+type ScheduledTransferModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+// Insert schedules st for bankId, which must own the source account.
+func (m ScheduledTransferModel) Insert(st *ScheduledTransfer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+        SELECT
+            EXISTS (SELECT 1 FROM accounts WHERE id = $1 AND bank_id = $2),
+            EXISTS (SELECT 1 FROM accounts WHERE id = $3)`
+
+	var sourceFound, targetFound bool
+
+	err := m.ReadDb.QueryRowContext(ctx, query, st.SourceAccountId, st.BankId, st.TargetAccountId).Scan(&sourceFound, &targetFound)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !sourceFound:
+		return ErrSourceAccountNotFound
+	case !targetFound:
+		return ErrTargetAccountNotFound
+	}
+
+	st.Status = ScheduledTransferStatusActive
+	st.NextRunAt = &st.StartsAt
+
+	query = `
+        INSERT INTO scheduled_transfers (bank_id, source_account_id, target_account_id, amount_in_cents, description, frequency, every, starts_at, ends_at, max_occurrences, next_run_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $8)
+        RETURNING id, created_at, version`
+
+	args := []interface{}{
+		st.BankId,
+		st.SourceAccountId,
+		st.TargetAccountId,
+		st.AmountInCents,
+		st.Description,
+		st.Frequency,
+		st.Every,
+		st.StartsAt,
+		st.EndsAt,
+		st.MaxOccurrences,
+	}
+
+	return m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&st.Id, &st.CreatedAt, &st.Version)
+}
+
+func (m ScheduledTransferModel) Get(id int64, bankId int64) (*ScheduledTransfer, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, bank_id, source_account_id, target_account_id, amount_in_cents, description, frequency, every, starts_at, ends_at, max_occurrences, occurrences, retries, next_run_at, status, created_at, version
+        FROM scheduled_transfers
+        WHERE id = $1 AND bank_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	st, err := scanScheduledTransfer(m.ReadDb.QueryRowContext(ctx, query, id, bankId))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return st, nil
+}
+
+// Cancel stops st from running again. Only active scheduled transfers can be
+// cancelled.
+func (m ScheduledTransferModel) Cancel(st *ScheduledTransfer) error {
+	query := `
+        UPDATE scheduled_transfers
+        SET status = $1, next_run_at = NULL, version = version + 1
+        WHERE id = $2 AND bank_id = $3 AND status = $4
+        RETURNING version`
+
+	args := []interface{}{ScheduledTransferStatusCancelled, st.Id, st.BankId, ScheduledTransferStatusActive}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&st.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrScheduledTransferNotActive
+		default:
+			return err
+		}
+	}
+
+	st.Status = ScheduledTransferStatusCancelled
+	st.NextRunAt = nil
+
+	return nil
+}
+
+func (m ScheduledTransferModel) GetAll(bankId int64, status string, accountId int64, filters Filters) ([]*ScheduledTransfer, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, bank_id, source_account_id, target_account_id, amount_in_cents, description, frequency, every, starts_at, ends_at, max_occurrences, occurrences, retries, next_run_at, status, created_at, version
+        FROM scheduled_transfers
+        WHERE bank_id = $1
+        AND ($2::text = '' OR status = $2)
+        AND ($3::bigint = 0 OR source_account_id = $3 OR target_account_id = $3)
+        ORDER BY %s %s NULLS LAST, id ASC
+        LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, bankId, status, accountId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	scheduled := []*ScheduledTransfer{}
+
+	for rows.Next() {
+		var st ScheduledTransfer
+
+		err := rows.Scan(
+			&totalRecords,
+			&st.Id,
+			&st.BankId,
+			&st.SourceAccountId,
+			&st.TargetAccountId,
+			&st.AmountInCents,
+			&st.Description,
+			&st.Frequency,
+			&st.Every,
+			&st.StartsAt,
+			&st.EndsAt,
+			&st.MaxOccurrences,
+			&st.Occurrences,
+			&st.Retries,
+			&st.NextRunAt,
+			&st.Status,
+			&st.CreatedAt,
+			&st.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		scheduled = append(scheduled, &st)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return scheduled, metadata, nil
+}
+
+func (m ScheduledTransferModel) GetRuns(scheduledTransferId int64, filters Filters) ([]*ScheduledTransferRun, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, scheduled_transfer_id, occurrence, scheduled_for, attempt, status, COALESCE(transfer_id, 0), error, created_at
+        FROM scheduled_transfer_runs
+        WHERE scheduled_transfer_id = $1
+        ORDER BY %s %s, id ASC
+        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, scheduledTransferId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	runs := []*ScheduledTransferRun{}
+
+	for rows.Next() {
+		var run ScheduledTransferRun
+
+		err := rows.Scan(
+			&totalRecords,
+			&run.Id,
+			&run.ScheduledTransferId,
+			&run.Occurrence,
+			&run.ScheduledFor,
+			&run.Attempt,
+			&run.Status,
+			&run.TransferId,
+			&run.Error,
+			&run.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		runs = append(runs, &run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return runs, metadata, nil
+}
+
+// RunNext makes one attempt at the scheduled transfer that has been due the
+// longest, returning nil if none are due. Attempts refused for insufficient
+// funds are retried after retryDelay, up to maxRetries times. Once an attempt
+// succeeds, or fails for good, the scheduled transfer moves on to its next
+// occurrence. Due rows are claimed with SKIP LOCKED, so several workers can
+// run side by side. frozenBankPayments decides whether transfers into a frozen
+// bank's accounts go through.
+func (m ScheduledTransferModel) RunNext(maxRetries int, retryDelay time.Duration, frozenBankPayments string) (*ScheduledTransfer, *ScheduledTransferRun, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT id, bank_id, source_account_id, target_account_id, amount_in_cents, description, frequency, every, starts_at, ends_at, max_occurrences, occurrences, retries, next_run_at, status, created_at, version
+        FROM scheduled_transfers
+        WHERE status = 'active' AND next_run_at <= now()
+        ORDER BY next_run_at, id
+        LIMIT 1
+        FOR UPDATE SKIP LOCKED`
+
+	st, err := scanScheduledTransfer(tx.QueryRowContext(ctx, query))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, nil
+		default:
+			return nil, nil, err
+		}
+	}
+
+	run := &ScheduledTransferRun{
+		ScheduledTransferId: st.Id,
+		Occurrence:          st.Occurrences + 1,
+		ScheduledFor:        st.occurrence(st.Occurrences),
+		Attempt:             st.Retries + 1,
+	}
+
+	// a refused transfer is rolled back to here, keeping the claim on st
+	_, err = tx.ExecContext(ctx, `SAVEPOINT scheduled_transfer_run`)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transfer := &Transfer{
+		SourceAccountId: st.SourceAccountId,
+		TargetAccountId: st.TargetAccountId,
+		AmountInCents:   st.AmountInCents,
+	}
+
+	err = insertTransfer(ctx, tx, transfer, st.BankId, frozenBankPayments)
+
+	switch {
+	case err == nil:
+		run.Status = ScheduledRunStatusSucceeded
+		run.TransferId = transfer.Id
+		st.advance()
+	case refused(err):
+		_, rollbackErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT scheduled_transfer_run`)
+		if rollbackErr != nil {
+			return nil, nil, rollbackErr
+		}
+
+		run.Error = err.Error()
+
+		if errors.Is(err, ErrInsufficientFunds) && st.Retries < maxRetries {
+			run.Status = ScheduledRunStatusRetrying
+			st.Retries++
+
+			retryAt := time.Now().Add(retryDelay)
+			st.NextRunAt = &retryAt
+		} else {
+			run.Status = ScheduledRunStatusFailed
+			st.advance()
+		}
+	default:
+		return nil, nil, err
+	}
+
+	query = `
+        INSERT INTO scheduled_transfer_runs (scheduled_transfer_id, occurrence, scheduled_for, attempt, status, transfer_id, error)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6::bigint, 0), $7)
+        RETURNING id, created_at`
+
+	args := []interface{}{run.ScheduledTransferId, run.Occurrence, run.ScheduledFor, run.Attempt, run.Status, run.TransferId, run.Error}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&run.Id, &run.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query = `
+        UPDATE scheduled_transfers
+        SET occurrences = $1, retries = $2, next_run_at = $3, status = $4, version = version + 1
+        WHERE id = $5
+        RETURNING version`
+
+	args = []interface{}{st.Occurrences, st.Retries, st.NextRunAt, st.Status, st.Id}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&st.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return st, run, nil
+}
+
+func refused(err error) bool {
+	for _, refusal := range transferRefusals {
+		if errors.Is(err, refusal) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func scanScheduledTransfer(row *sql.Row) (*ScheduledTransfer, error) {
+	var st ScheduledTransfer
+
+	err := row.Scan(
+		&st.Id,
+		&st.BankId,
+		&st.SourceAccountId,
+		&st.TargetAccountId,
+		&st.AmountInCents,
+		&st.Description,
+		&st.Frequency,
+		&st.Every,
+		&st.StartsAt,
+		&st.EndsAt,
+		&st.MaxOccurrences,
+		&st.Occurrences,
+		&st.Retries,
+		&st.NextRunAt,
+		&st.Status,
+		&st.CreatedAt,
+		&st.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &st, nil
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduledTransferOccurrence(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 9, 30, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		frequency string
+		every     int
+		startsAt  time.Time
+		n         int
+		want      time.Time
+	}{
+		{"once ignores n", FrequencyOnce, 1, date(2026, time.March, 4), 5, date(2026, time.March, 4)},
+		{"first occurrence is the start", FrequencyMonthly, 1, date(2026, time.January, 31), 0, date(2026, time.January, 31)},
+		{"daily", FrequencyDaily, 1, date(2026, time.December, 30), 3, date(2027, time.January, 2)},
+		{"daily every 3", FrequencyDaily, 3, date(2026, time.March, 1), 2, date(2026, time.March, 7)},
+		{"weekly every 2", FrequencyWeekly, 2, date(2026, time.March, 4), 3, date(2026, time.April, 15)},
+		{"monthly end of month into february", FrequencyMonthly, 1, date(2026, time.January, 31), 1, date(2026, time.February, 28)},
+		{"monthly end of month into leap february", FrequencyMonthly, 1, date(2028, time.January, 31), 1, date(2028, time.February, 29)},
+		{"monthly end of month recovers after a short month", FrequencyMonthly, 1, date(2026, time.January, 31), 2, date(2026, time.March, 31)},
+		{"monthly end of month into a 30 day month", FrequencyMonthly, 1, date(2026, time.January, 31), 3, date(2026, time.April, 30)},
+		{"monthly every 2", FrequencyMonthly, 2, date(2026, time.January, 31), 2, date(2026, time.May, 31)},
+		{"monthly every 3 across a year", FrequencyMonthly, 3, date(2026, time.November, 30), 1, date(2027, time.February, 28)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &ScheduledTransfer{Frequency: tt.frequency, Every: tt.every, StartsAt: tt.startsAt}
+
+			got := st.occurrence(tt.n)
+			if !got.Equal(tt.want) {
+				t.Errorf("occurrence(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduledTransferAdvance(t *testing.T) {
+	startsAt := time.Date(2026, time.March, 1, 9, 30, 0, 0, time.UTC)
+
+	at := func(t time.Time) *time.Time {
+		return &t
+	}
+
+	tests := []struct {
+		name           string
+		frequency      string
+		every          int
+		occurrences    int
+		maxOccurrences int
+		endsAt         *time.Time
+		wantStatus     string
+		wantNextRunAt  *time.Time
+	}{
+		{"once completes", FrequencyOnce, 1, 0, 0, nil, ScheduledTransferStatusCompleted, nil},
+		{"open ended keeps going", FrequencyDaily, 1, 4, 0, nil, ScheduledTransferStatusActive, at(startsAt.AddDate(0, 0, 5))},
+		{"every 2 skips ahead", FrequencyWeekly, 2, 0, 0, nil, ScheduledTransferStatusActive, at(startsAt.AddDate(0, 0, 14))},
+		{"before max occurrences", FrequencyDaily, 1, 1, 3, nil, ScheduledTransferStatusActive, at(startsAt.AddDate(0, 0, 2))},
+		{"at max occurrences", FrequencyDaily, 1, 2, 3, nil, ScheduledTransferStatusCompleted, nil},
+		{"next occurrence on ends_at", FrequencyDaily, 1, 0, 0, at(startsAt.AddDate(0, 0, 1)), ScheduledTransferStatusActive, at(startsAt.AddDate(0, 0, 1))},
+		{"next occurrence just after ends_at", FrequencyDaily, 1, 0, 0, at(startsAt.AddDate(0, 0, 1).Add(-time.Second)), ScheduledTransferStatusCompleted, nil},
+		{"ends_at before max occurrences", FrequencyMonthly, 1, 0, 12, at(startsAt.AddDate(0, 0, 20)), ScheduledTransferStatusCompleted, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &ScheduledTransfer{
+				Frequency:      tt.frequency,
+				Every:          tt.every,
+				StartsAt:       startsAt,
+				EndsAt:         tt.endsAt,
+				MaxOccurrences: tt.maxOccurrences,
+				Occurrences:    tt.occurrences,
+				Retries:        2,
+				Status:         ScheduledTransferStatusActive,
+			}
+
+			st.advance()
+
+			if st.Occurrences != tt.occurrences+1 {
+				t.Errorf("Occurrences = %d, want %d", st.Occurrences, tt.occurrences+1)
+			}
+
+			if st.Retries != 0 {
+				t.Errorf("Retries = %d, want 0", st.Retries)
+			}
+
+			if st.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", st.Status, tt.wantStatus)
+			}
+
+			switch {
+			case tt.wantNextRunAt == nil && st.NextRunAt != nil:
+				t.Errorf("NextRunAt = %v, want nil", *st.NextRunAt)
+			case tt.wantNextRunAt != nil && st.NextRunAt == nil:
+				t.Errorf("NextRunAt = nil, want %v", *tt.wantNextRunAt)
+			case tt.wantNextRunAt != nil && !st.NextRunAt.Equal(*tt.wantNextRunAt):
+				t.Errorf("NextRunAt = %v, want %v", *st.NextRunAt, *tt.wantNextRunAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestModels migrates a fresh schema in the database named by
+// RESERVA_TEST_DB_DSN and returns models that use it. The schema is dropped
+// when the test ends. Tests that need it are skipped when the variable isn't
+// set.
+func newTestModels(t *testing.T) Models {
+	t.Helper()
+
+	dsn := os.Getenv("RESERVA_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("RESERVA_TEST_DB_DSN is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+
+	_, err = admin.Exec(`CREATE EXTENSION IF NOT EXISTS citext WITH SCHEMA public`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		admin, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer admin.Close()
+
+		_, err = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	searchPath := schema + ",public"
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "search_path=" + url.QueryEscape(searchPath)
+	} else {
+		dsn += " search_path=" + searchPath
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+
+	for _, migration := range migrations {
+		statements, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec(string(statements))
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(migration), err)
+		}
+	}
+
+	return NewModels(db, db)
+}
+
+// insertTestBank inserts an activated bank with role, named name.
+func insertTestBank(t *testing.T, models Models, name string, role string) *Bank {
+	t.Helper()
+
+	bank := &Bank{
+		Name:      name,
+		Email:     name + "@example.com",
+		Role:      role,
+		Activated: true,
+	}
+
+	err := bank.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Banks.Insert(bank)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bank
+}
+
+func insertTestAccount(t *testing.T, models Models, bank *Bank) *Account {
+	t.Helper()
+
+	account := &Account{BankId: bank.Id}
+
+	err := models.Accounts.Insert(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account
+}
+
+func issueTestReserves(t *testing.T, models Models, bank *Bank, amountInCents int64) {
+	t.Helper()
+
+	op := &ReserveOperation{
+		BankId:        bank.Id,
+		Kind:          ReserveOperationIssuance,
+		AmountInCents: amountInCents,
+		Operator:      "central@example.com",
+		Reason:        "test",
+		Reference:     "test",
+	}
+
+	err := models.Reserves.Insert(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -21,6 +21,7 @@ type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	BankID    int64     `json:"-"`
+	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
 }
@@ -67,12 +68,25 @@ func (m TokenModel) New(bankID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewForUser issues a token to user rather than to their bank.
+func (m TokenModel) NewForUser(user *User, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(user.BankId, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.UserID = user.Id
+
+	err = m.Insert(token)
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-        INSERT INTO tokens (hash, bank_id, expiry, scope) 
-        VALUES ($1, $2, $3, $4)`
+        INSERT INTO tokens (hash, bank_id, user_id, expiry, scope) 
+        VALUES ($1, $2, NULLIF($3::bigint, 0), $4, $5)`
 
-	args := []interface{}{token.Hash, token.BankID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.BankID, token.UserID, token.Expiry, token.Scope}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -84,7 +98,7 @@ func (m TokenModel) Insert(token *Token) error {
 func (m TokenModel) DeleteAllForBank(scope string, bankID int64) error {
 	query := `
         DELETE FROM tokens 
-        WHERE scope = $1 AND bank_id = $2`
+        WHERE scope = $1 AND bank_id = $2 AND user_id IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -92,3 +106,29 @@ func (m TokenModel) DeleteAllForBank(scope string, bankID int64) error {
 	_, err := m.WriteDb.ExecContext(ctx, query, scope, bankID)
 	return err
 }
+
+func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE scope = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.WriteDb.ExecContext(ctx, query, scope, userID)
+	return err
+}
+
+// DeleteAllScopesForUser signs user out everywhere and voids any activation or
+// password reset tokens they hold.
+func (m TokenModel) DeleteAllScopesForUser(userID int64) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.WriteDb.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,614 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrSourceAccountNotFound = errors.New("source account not found")
+	ErrTargetAccountNotFound = errors.New("target account not found")
+	ErrAccountFrozen         = errors.New("account frozen")
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+	ErrRefundNotPermitted    = errors.New("refund not permitted")
+	ErrRefundOfRefund        = errors.New("refund of refund")
+	ErrTransferRefunded      = errors.New("transfer fully refunded")
+	ErrRefundExceedsTransfer = errors.New("refund exceeds transfer")
+)
+
+// OverflowInCents is the part of AmountInCents that went to the target
+// account's overflow account instead of the target, because the target would
+// otherwise have gone over its holding limit.
+//
+// A refund is itself a transfer, from the original target back to the original
+// source, linked by RefundOfTransferId. RefundedInCents tracks how much of a
+// transfer has been refunded so far. Only what the target actually received
+// can be refunded, never the overflow.
+//
+// UserId is the user who made the transfer, or 0 when the bank's own
+// credentials were used or the system moved the money itself.
+type Transfer struct {
+	Id                 int64     `json:"id"`
+	SourceAccountId    int64     `json:"source_account_id"`
+	TargetAccountId    int64     `json:"target_account_id"`
+	AmountInCents      int64     `json:"amount_in_cents"`
+	OverflowAccountId  int64     `json:"overflow_account_id,omitempty"`
+	OverflowInCents    int64     `json:"overflow_in_cents"`
+	RefundOfTransferId int64     `json:"refund_of_transfer_id,omitempty"`
+	RefundReason       string    `json:"refund_reason,omitempty"`
+	RefundedInCents    int64     `json:"refunded_in_cents"`
+	RefundStatus       string    `json:"refund_status"`
+	PostingId          int64     `json:"posting_id"`
+	Direction          string    `json:"direction"`
+	UserId             int64     `json:"user_id,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+const (
+	RefundStatusNone     = "none"
+	RefundStatusPartial  = "partial"
+	RefundStatusRefunded = "refunded"
+)
+
+// transferRefundStatusColumn reports how much of a transfer has been refunded.
+// It expects the transfers table to be in scope.
+const transferRefundStatusColumn = `
+        CASE
+            WHEN transfers.refunded_in_cents = 0 THEN 'none'
+            WHEN transfers.refunded_in_cents < transfers.amount_in_cents - transfers.overflow_in_cents THEN 'partial'
+            ELSE 'refunded'
+        END`
+
+const (
+	DirectionAll      = "all"
+	DirectionIncoming = "incoming"
+	DirectionOutgoing = "outgoing"
+	DirectionInternal = "internal"
+)
+
+// transferDirectionColumn reports a transfer's direction relative to the bank
+// passed as $1. It expects the accounts table to be joined as source and target.
+const transferDirectionColumn = `
+        CASE
+            WHEN source.bank_id = $1 AND target.bank_id = $1 THEN 'internal'
+            WHEN source.bank_id = $1 THEN 'outgoing'
+            ELSE 'incoming'
+        END`
+
+// TransferQuery narrows a transfer search. Zero values mean "don't filter".
+type TransferQuery struct {
+	AccountId          int64
+	SourceAccountId    int64
+	RefundOfTransferId int64
+	TargetAccountId    int64
+	Direction          string
+	MinAmountInCents   int64
+	MaxAmountInCents   int64
+	CreatedAfter       *time.Time
+	CreatedBefore      *time.Time
+}
+
+func ValidateTransferQuery(v *validator.Validator, q TransferQuery) {
+	v.Check(q.AccountId >= 0, "account_id", "must not be negative")
+	v.Check(q.SourceAccountId >= 0, "source_account_id", "must not be negative")
+	v.Check(q.TargetAccountId >= 0, "target_account_id", "must not be negative")
+	v.Check(q.RefundOfTransferId >= 0, "refund_of_transfer_id", "must not be negative")
+	v.Check(validator.PermittedValue(q.Direction, DirectionAll, DirectionIncoming, DirectionOutgoing), "direction", "must be one of all, incoming or outgoing")
+	v.Check(q.MinAmountInCents >= 0, "min_amount_in_cents", "must not be negative")
+	v.Check(q.MaxAmountInCents >= 0, "max_amount_in_cents", "must not be negative")
+
+	if q.MaxAmountInCents != 0 {
+		v.Check(q.MinAmountInCents <= q.MaxAmountInCents, "min_amount_in_cents", "must not be greater than max_amount_in_cents")
+	}
+
+	if q.CreatedAfter != nil && q.CreatedBefore != nil {
+		v.Check(q.CreatedAfter.Before(*q.CreatedBefore), "created_after", "must be before created_before")
+	}
+}
+
+func ValidateTransfer(v *validator.Validator, transfer *Transfer) {
+	v.Check(transfer.SourceAccountId != 0, "source_account_id", "must be provided")
+	v.Check(transfer.SourceAccountId > 0, "source_account_id", "must be greater than 0")
+	v.Check(transfer.TargetAccountId != 0, "target_account_id", "must be provided")
+	v.Check(transfer.TargetAccountId > 0, "target_account_id", "must be greater than 0")
+	v.Check(transfer.SourceAccountId != transfer.TargetAccountId, "target_account_id", "must be different from source_account_id")
+	v.Check(transfer.AmountInCents > 0, "amount_in_cents", "must be greater than 0")
+}
+
+func ValidateRefund(v *validator.Validator, refund *Transfer) {
+	v.Check(refund.AmountInCents >= 0, "amount_in_cents", "must not be negative")
+	v.Check(refund.RefundReason != "", "reason", "must be provided")
+	v.Check(utf8.RuneCountInString(refund.RefundReason) <= 500, "reason", "must not be more than 500 characters long")
+}
+
+type TransferModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+func (m TransferModel) Insert(transfer *Transfer, bankId int64, frozenBankPayments string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertTransfer(ctx, tx, transfer, bankId, frozenBankPayments)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertTransfer moves funds between two accounts inside tx. The source
+// account must belong to bankId, which can't be frozen, the target account may
+// belong to any bank, frozen or not as frozenBankPayments allows.
+// Whatever would take the target over its holding limit is sent on to its
+// overflow account. Money credited to another bank's account is settled by
+// moving the same amount from the source bank's reserve to the other bank's,
+// so a bank's accounts stay backed by what the central bank issued. All rows
+// are locked in id order, banks before accounts, so concurrent transfers can't
+// deadlock.
+func insertTransfer(ctx context.Context, tx *sql.Tx, transfer *Transfer, bankId int64, frozenBankPayments string) error {
+	err := lockTransferBanks(ctx, tx, transfer)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        SELECT id, bank_id, balance_in_cents, holding_limit_in_cents, COALESCE(overflow_account_id, 0), status
+        FROM accounts
+        WHERE id = $1 OR id = $2 OR id = (SELECT overflow_account_id FROM accounts WHERE id = $2)
+        ORDER BY id
+        FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, transfer.SourceAccountId, transfer.TargetAccountId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	accounts := make(map[int64]*Account)
+
+	for rows.Next() {
+		var account Account
+
+		err := rows.Scan(
+			&account.Id,
+			&account.BankId,
+			&account.BalanceInCents,
+			&account.HoldingLimitInCents,
+			&account.OverflowAccountId,
+			&account.Status,
+		)
+		if err != nil {
+			return err
+		}
+
+		accounts[account.Id] = &account
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	source := accounts[transfer.SourceAccountId]
+	target := accounts[transfer.TargetAccountId]
+
+	switch {
+	case source == nil || source.BankId != bankId:
+		return ErrSourceAccountNotFound
+	case target == nil:
+		return ErrTargetAccountNotFound
+	}
+
+	err = source.usable()
+	if err == nil {
+		err = target.usable()
+	}
+	if err != nil {
+		return err
+	}
+
+	err = checkBankNotFrozen(ctx, tx, source.BankId)
+	if err != nil {
+		return err
+	}
+
+	err = checkTargetBank(ctx, tx, target.BankId, frozenBankPayments)
+	if err != nil {
+		return err
+	}
+
+	if source.BalanceInCents < transfer.AmountInCents {
+		return ErrInsufficientFunds
+	}
+
+	transfer.Direction = DirectionOutgoing
+	if target.BankId == bankId {
+		transfer.Direction = DirectionInternal
+	}
+
+	kind := PostingKindTransfer
+	if transfer.RefundOfTransferId != 0 {
+		kind = PostingKindRefund
+	}
+
+	posting := &Posting{
+		Kind: kind,
+		Entries: []*LedgerEntry{
+			{AccountId: source.Id, AmountInCents: -transfer.AmountInCents},
+		},
+	}
+
+	transfer.OverflowAccountId = 0
+	transfer.OverflowInCents = 0
+
+	if target.HoldingLimitInCents != 0 && target.BalanceInCents+transfer.AmountInCents > target.HoldingLimitInCents {
+		overflow := accounts[target.OverflowAccountId]
+
+		// the overflow account may have changed between reading and locking
+		// the target, in which case the credit is rejected like any other
+		if target.OverflowAccountId == 0 || overflow == nil {
+			return ErrHoldingLimitExceeded
+		}
+
+		err = overflow.usable()
+		if err != nil {
+			return err
+		}
+
+		err = checkTargetBank(ctx, tx, overflow.BankId, frozenBankPayments)
+		if err != nil {
+			return err
+		}
+
+		transfer.OverflowAccountId = overflow.Id
+		transfer.OverflowInCents = transfer.AmountInCents
+
+		if target.BalanceInCents < target.HoldingLimitInCents {
+			transfer.OverflowInCents -= target.HoldingLimitInCents - target.BalanceInCents
+		}
+
+		posting.Entries = append(posting.Entries, &LedgerEntry{AccountId: overflow.Id, AmountInCents: transfer.OverflowInCents})
+	}
+
+	if transfer.AmountInCents > transfer.OverflowInCents {
+		posting.Entries = append(posting.Entries, &LedgerEntry{AccountId: target.Id, AmountInCents: transfer.AmountInCents - transfer.OverflowInCents})
+	}
+
+	var settlement []*LedgerEntry
+	var settledInCents int64
+
+	for _, entry := range posting.Entries[1:] {
+		creditBankId := accounts[entry.AccountId].BankId
+
+		if creditBankId != source.BankId {
+			settlement = append(settlement, &LedgerEntry{BankId: creditBankId, AmountInCents: entry.AmountInCents})
+			settledInCents += entry.AmountInCents
+		}
+	}
+
+	if settledInCents > 0 {
+		var reserveInCents int64
+
+		query = `
+            SELECT balance_in_cents
+            FROM banks
+            WHERE id = $1
+            FOR UPDATE`
+
+		err = tx.QueryRowContext(ctx, query, source.BankId).Scan(&reserveInCents)
+		if err != nil {
+			return err
+		}
+
+		if reserveInCents < settledInCents {
+			return ErrInsufficientReserves
+		}
+
+		posting.Entries = append(posting.Entries, &LedgerEntry{BankId: source.BankId, AmountInCents: -settledInCents})
+		posting.Entries = append(posting.Entries, settlement...)
+	}
+
+	err = post(ctx, tx, posting)
+	if err != nil {
+		return err
+	}
+
+	transfer.PostingId = posting.Id
+	transfer.RefundedInCents = 0
+	transfer.RefundStatus = RefundStatusNone
+
+	query = `
+        INSERT INTO transfers (source_account_id, target_account_id, amount_in_cents, overflow_account_id, overflow_in_cents, refund_of_transfer_id, refund_reason, posting_id, user_id)
+        VALUES ($1, $2, $3, NULLIF($4::bigint, 0), $5, NULLIF($6::bigint, 0), $7, $8, NULLIF($9::bigint, 0))
+        RETURNING id, created_at`
+
+	args := []interface{}{
+		transfer.SourceAccountId,
+		transfer.TargetAccountId,
+		transfer.AmountInCents,
+		transfer.OverflowAccountId,
+		transfer.OverflowInCents,
+		transfer.RefundOfTransferId,
+		transfer.RefundReason,
+		transfer.PostingId,
+		transfer.UserId,
+	}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&transfer.Id, &transfer.CreatedAt)
+}
+
+// lockTransferBanks locks the banks of the accounts transfer touches when there
+// is more than one, since the transfer then settles between their reserves.
+// Transfers within a bank don't lock it, so they don't queue behind each other.
+func lockTransferBanks(ctx context.Context, tx *sql.Tx, transfer *Transfer) error {
+	query := `
+        SELECT DISTINCT bank_id
+        FROM accounts
+        WHERE id = $1 OR id = $2 OR id = (SELECT overflow_account_id FROM accounts WHERE id = $2)
+        ORDER BY bank_id`
+
+	rows, err := tx.QueryContext(ctx, query, transfer.SourceAccountId, transfer.TargetAccountId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var bankIds []int64
+
+	for rows.Next() {
+		var bankId int64
+
+		err := rows.Scan(&bankId)
+		if err != nil {
+			return err
+		}
+
+		bankIds = append(bankIds, bankId)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(bankIds) < 2 {
+		return nil
+	}
+
+	query = `
+        SELECT id
+        FROM banks
+        WHERE id = ANY($1)
+        ORDER BY id
+        FOR UPDATE`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(bankIds))
+	return err
+}
+
+// Refund sends refund.AmountInCents of transfer refund.RefundOfTransferId back
+// from its target account to its source, or whatever is left to refund if
+// refund.AmountInCents is 0. Only the bank of the original target account may
+// refund it. The original transfer row is locked for the duration, so
+// concurrent refunds can never add up to more than the original amount.
+func (m TransferModel) Refund(refund *Transfer, bankId int64, frozenBankPayments string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.WriteDb.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT transfers.source_account_id, transfers.target_account_id, transfers.amount_in_cents, transfers.overflow_in_cents, transfers.refunded_in_cents, COALESCE(transfers.refund_of_transfer_id, 0), source.bank_id, target.bank_id
+        FROM transfers
+        INNER JOIN accounts source ON transfers.source_account_id = source.id
+        INNER JOIN accounts target ON transfers.target_account_id = target.id
+        WHERE transfers.id = $1 AND (source.bank_id = $2 OR target.bank_id = $2)
+        FOR UPDATE OF transfers`
+
+	var original Transfer
+	var sourceBankId, targetBankId int64
+
+	err = tx.QueryRowContext(ctx, query, refund.RefundOfTransferId, bankId).Scan(
+		&original.SourceAccountId,
+		&original.TargetAccountId,
+		&original.AmountInCents,
+		&original.OverflowInCents,
+		&original.RefundedInCents,
+		&original.RefundOfTransferId,
+		&sourceBankId,
+		&targetBankId,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	// the overflow account may be at another bank, so the refund comes only
+	// out of what the target itself received
+	remaining := original.AmountInCents - original.OverflowInCents - original.RefundedInCents
+
+	if refund.AmountInCents == 0 {
+		refund.AmountInCents = remaining
+	}
+
+	switch {
+	case targetBankId != bankId:
+		return ErrRefundNotPermitted
+	case original.RefundOfTransferId != 0:
+		return ErrRefundOfRefund
+	case remaining == 0:
+		return ErrTransferRefunded
+	case refund.AmountInCents > remaining:
+		return ErrRefundExceedsTransfer
+	}
+
+	refund.SourceAccountId = original.TargetAccountId
+	refund.TargetAccountId = original.SourceAccountId
+
+	err = insertTransfer(ctx, tx, refund, bankId, frozenBankPayments)
+	if err != nil {
+		return err
+	}
+
+	query = `
+        UPDATE transfers
+        SET refunded_in_cents = refunded_in_cents + $1
+        WHERE id = $2`
+
+	_, err = tx.ExecContext(ctx, query, refund.AmountInCents, refund.RefundOfTransferId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m TransferModel) Get(id int64, bankId int64) (*Transfer, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+        SELECT transfers.id, transfers.source_account_id, transfers.target_account_id, transfers.amount_in_cents, COALESCE(transfers.overflow_account_id, 0), transfers.overflow_in_cents, COALESCE(transfers.refund_of_transfer_id, 0), transfers.refund_reason, transfers.refunded_in_cents, %s, COALESCE(transfers.posting_id, 0), %s, COALESCE(transfers.user_id, 0), transfers.created_at
+        FROM transfers
+        INNER JOIN accounts source ON transfers.source_account_id = source.id
+        INNER JOIN accounts target ON transfers.target_account_id = target.id
+        WHERE (source.bank_id = $1 OR target.bank_id = $1) AND transfers.id = $2`, transferRefundStatusColumn, transferDirectionColumn)
+
+	var transfer Transfer
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.ReadDb.QueryRowContext(ctx, query, bankId, id).Scan(
+		&transfer.Id,
+		&transfer.SourceAccountId,
+		&transfer.TargetAccountId,
+		&transfer.AmountInCents,
+		&transfer.OverflowAccountId,
+		&transfer.OverflowInCents,
+		&transfer.RefundOfTransferId,
+		&transfer.RefundReason,
+		&transfer.RefundedInCents,
+		&transfer.RefundStatus,
+		&transfer.PostingId,
+		&transfer.Direction,
+		&transfer.UserId,
+		&transfer.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &transfer, nil
+}
+
+func (m TransferModel) GetAll(bankId int64, q TransferQuery, filters Filters) ([]*Transfer, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), transfers.id, transfers.source_account_id, transfers.target_account_id, transfers.amount_in_cents, COALESCE(transfers.overflow_account_id, 0), transfers.overflow_in_cents, COALESCE(transfers.refund_of_transfer_id, 0), transfers.refund_reason, transfers.refunded_in_cents, %s, COALESCE(transfers.posting_id, 0), %s, COALESCE(transfers.user_id, 0), transfers.created_at
+        FROM transfers
+        INNER JOIN accounts source ON transfers.source_account_id = source.id
+        INNER JOIN accounts target ON transfers.target_account_id = target.id
+        WHERE (source.bank_id = $1 OR target.bank_id = $1)
+        AND ($2::text = 'all' OR ($2 = 'outgoing' AND source.bank_id = $1) OR ($2 = 'incoming' AND target.bank_id = $1))
+        AND ($3::bigint = 0 OR transfers.source_account_id = $3 OR transfers.target_account_id = $3)
+        AND ($4::bigint = 0 OR transfers.source_account_id = $4)
+        AND ($5::bigint = 0 OR transfers.target_account_id = $5)
+        AND ($6::bigint = 0 OR transfers.amount_in_cents >= $6)
+        AND ($7::bigint = 0 OR transfers.amount_in_cents <= $7)
+        AND ($8::timestamptz IS NULL OR transfers.created_at >= $8)
+        AND ($9::timestamptz IS NULL OR transfers.created_at < $9)
+        AND ($10::bigint = 0 OR transfers.refund_of_transfer_id = $10)
+        ORDER BY transfers.%s %s, transfers.id ASC
+        LIMIT $11 OFFSET $12`, transferRefundStatusColumn, transferDirectionColumn, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		bankId,
+		q.Direction,
+		q.AccountId,
+		q.SourceAccountId,
+		q.TargetAccountId,
+		q.MinAmountInCents,
+		q.MaxAmountInCents,
+		q.CreatedAfter,
+		q.CreatedBefore,
+		q.RefundOfTransferId,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	transfers := []*Transfer{}
+
+	for rows.Next() {
+		var transfer Transfer
+
+		err := rows.Scan(
+			&totalRecords,
+			&transfer.Id,
+			&transfer.SourceAccountId,
+			&transfer.TargetAccountId,
+			&transfer.AmountInCents,
+			&transfer.OverflowAccountId,
+			&transfer.OverflowInCents,
+			&transfer.RefundOfTransferId,
+			&transfer.RefundReason,
+			&transfer.RefundedInCents,
+			&transfer.RefundStatus,
+			&transfer.PostingId,
+			&transfer.Direction,
+			&transfer.UserId,
+			&transfer.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		transfers = append(transfers, &transfer)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return transfers, metadata, nil
+}
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCrossBankTransferSettlesReserves(t *testing.T) {
+	models := newTestModels(t)
+
+	insertTestBank(t, models, "central", RoleCentralBank)
+	sender := insertTestBank(t, models, "sender", RoleCommercialBank)
+	receiver := insertTestBank(t, models, "receiver", RoleCommercialBank)
+
+	source := insertTestAccount(t, models, sender)
+	target := insertTestAccount(t, models, receiver)
+
+	issueTestReserves(t, models, sender, 100)
+
+	_, err := models.Accounts.CashIn(source, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// everything issued to the sender is in its account, so nothing is left in
+	// its reserve to settle with
+	transfer := &Transfer{SourceAccountId: source.Id, TargetAccountId: target.Id, AmountInCents: 100}
+
+	err = models.Transfers.Insert(transfer, sender.Id, FrozenBankPaymentsAccept)
+	if !errors.Is(err, ErrInsufficientReserves) {
+		t.Fatalf("Transfers.Insert() error = %v, want %v", err, ErrInsufficientReserves)
+	}
+
+	// the receiver was issued nothing, so it can't cash anything out
+	_, err = models.Accounts.CashOut(target, 1)
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Accounts.CashOut() error = %v, want %v", err, ErrInsufficientFunds)
+	}
+
+	issueTestReserves(t, models, sender, 100)
+
+	err = models.Transfers.Insert(transfer, sender.Id, FrozenBankPaymentsAccept)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balances := []struct {
+		name string
+		get  func() (int64, error)
+		want int64
+	}{
+		{"sender reserve", bankBalance(models, sender.Id), 0},
+		{"receiver reserve", bankBalance(models, receiver.Id), 100},
+		{"source account", accountBalance(models, source), 0},
+		{"target account", accountBalance(models, target), 100},
+	}
+
+	for _, b := range balances {
+		got, err := b.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != b.want {
+			t.Errorf("%s = %d, want %d", b.name, got, b.want)
+		}
+	}
+}
+
+func bankBalance(models Models, id int64) func() (int64, error) {
+	return func() (int64, error) {
+		bank, err := models.Banks.Get(id)
+		if err != nil {
+			return 0, err
+		}
+
+		return bank.BalanceInCents, nil
+	}
+}
+
+func accountBalance(models Models, account *Account) func() (int64, error) {
+	return func() (int64, error) {
+		got, err := models.Accounts.Get(account.Id, account.BankId)
+		if err != nil {
+			return 0, err
+		}
+
+		return got.BalanceInCents, nil
+	}
+}
@@ -0,0 +1,250 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calmitchell617/reserva/internal/validator"
+)
+
+const (
+	UserRoleReadOnly = "read_only"
+	UserRoleOperator = "operator"
+	UserRoleAdmin    = "admin"
+)
+
+var UserRoles = []string{UserRoleReadOnly, UserRoleOperator, UserRoleAdmin}
+
+// A User is one person working for a bank under their own credentials. Read
+// only users may only make read requests, operators may do anything their
+// bank's role allows, and admins may also manage the bank's users. Signing in
+// with the bank's own credentials counts as an admin.
+type User struct {
+	Id        int64     `json:"id"`
+	BankId    int64     `json:"bank_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  password  `json:"-"`
+	Role      string    `json:"role"`
+	Activated bool      `json:"activated"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int64     `json:"version"`
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(utf8.RuneCountInString(user.Name) <= 500, "name", "must not be more than 500 characters long")
+
+	ValidateEmail(v, user.Email)
+
+	v.Check(validator.PermittedValue(user.Role, UserRoles...), "role", "must be one of read_only, operator or admin")
+
+	if user.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+	}
+
+	if user.Password.hash == nil {
+		panic("missing password hash for user")
+	}
+}
+
+type UserModel struct {
+	WriteDb *sql.DB
+	ReadDb  *sql.DB
+}
+
+func (m UserModel) Insert(user *User) error {
+	query := `
+        INSERT INTO users (bank_id, name, email, password_hash, role)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at, version`
+
+	args := []interface{}{user.BankId, user.Name, user.Email, user.Password.hash, user.Role}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&user.Id, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m UserModel) Get(id int64, bankId int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, bank_id, name, email, password_hash, role, activated, disabled, created_at, version
+        FROM users
+        WHERE id = $1 AND bank_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanUser(m.ReadDb.QueryRowContext(ctx, query, id, bankId))
+}
+
+func (m UserModel) GetByEmail(email string) (*User, error) {
+	query := `
+        SELECT id, bank_id, name, email, password_hash, role, activated, disabled, created_at, version
+        FROM users
+        WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanUser(m.ReadDb.QueryRowContext(ctx, query, email))
+}
+
+// GetForToken finds the enabled user a token of tokenScope was issued to.
+// Tokens issued to a bank itself don't match.
+func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        SELECT users.id, users.bank_id, users.name, users.email, users.password_hash, users.role, users.activated, users.disabled, users.created_at, users.version
+        FROM users
+        INNER JOIN tokens
+        ON users.id = tokens.user_id
+        WHERE tokens.hash = $1
+        AND tokens.scope = $2
+        AND tokens.expiry > $3
+        AND NOT users.disabled`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanUser(m.ReadDb.QueryRowContext(ctx, query, tokenHash[:], tokenScope, time.Now()))
+}
+
+func (m UserModel) Update(user *User) error {
+	query := `
+        UPDATE users
+        SET name = $1, email = $2, password_hash = $3, role = $4, activated = $5, disabled = $6, version = version + 1
+        WHERE id = $7 AND version = $8
+        RETURNING version`
+
+	args := []interface{}{
+		user.Name,
+		user.Email,
+		user.Password.hash,
+		user.Role,
+		user.Activated,
+		user.Disabled,
+		user.Id,
+		user.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.WriteDb.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m UserModel) GetAll(bankId int64, role string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, bank_id, name, email, password_hash, role, activated, disabled, created_at, version
+        FROM users
+        WHERE bank_id = $1
+        AND ($2::text = '' OR role = $2)
+        ORDER BY %s %s, id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDb.QueryContext(ctx, query, bankId, role, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.Id,
+			&user.BankId,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Role,
+			&user.Activated,
+			&user.Disabled,
+			&user.CreatedAt,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+
+	err := row.Scan(
+		&user.Id,
+		&user.BankId,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Role,
+		&user.Activated,
+		&user.Disabled,
+		&user.CreatedAt,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
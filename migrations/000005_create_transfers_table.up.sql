@@ -0,0 +1,11 @@
+create table transfers (
+  id bigserial primary key,
+  source_account_id bigint not null references accounts,
+  target_account_id bigint not null references accounts,
+  amount_in_cents bigint not null check (amount_in_cents > 0),
+  created_at timestamp(0) with time zone not null default now(),
+  check (source_account_id <> target_account_id)
+);
+
+create index transfers_source_account_id_idx on transfers (source_account_id);
+create index transfers_target_account_id_idx on transfers (target_account_id);
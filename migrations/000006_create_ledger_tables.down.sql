@@ -0,0 +1,9 @@
+alter table transfers drop column if exists posting_id;
+
+drop table if exists ledger_entries;
+
+drop table if exists postings;
+
+drop function if exists check_posting_balanced;
+
+drop function if exists reject_ledger_change;
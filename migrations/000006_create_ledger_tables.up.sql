@@ -0,0 +1,72 @@
+create table postings (
+  id bigserial primary key,
+  kind text not null,
+  created_at timestamp(0) with time zone not null default now()
+);
+
+create table ledger_entries (
+  id bigserial primary key,
+  posting_id bigint not null references postings,
+  bank_id bigint references banks,
+  account_id bigint references accounts,
+  amount_in_cents bigint not null check (amount_in_cents <> 0),
+  created_at timestamp(0) with time zone not null default now(),
+  check (bank_id is null or account_id is null)
+);
+
+create index ledger_entries_posting_id_idx on ledger_entries (posting_id);
+create index ledger_entries_account_id_idx on ledger_entries (account_id);
+create index ledger_entries_bank_id_idx on ledger_entries (bank_id);
+
+create function reject_ledger_change() returns trigger as $$
+begin
+  raise exception '% is append-only', tg_table_name;
+end;
+$$ language plpgsql;
+
+create trigger postings_append_only
+  before update or delete on postings
+  for each row execute function reject_ledger_change();
+
+create trigger ledger_entries_append_only
+  before update or delete on ledger_entries
+  for each row execute function reject_ledger_change();
+
+create function check_posting_balanced() returns trigger as $$
+begin
+  if (select sum(amount_in_cents) from ledger_entries where posting_id = new.posting_id) <> 0 then
+    raise exception 'posting % does not sum to zero', new.posting_id;
+  end if;
+  return null;
+end;
+$$ language plpgsql;
+
+create constraint trigger ledger_entries_balanced
+  after insert on ledger_entries
+  deferrable initially deferred
+  for each row execute function check_posting_balanced();
+
+alter table transfers add column posting_id bigint references postings;
+
+-- balances from before the ledger each get an opening balance posting. The
+-- other leg belongs to neither a bank nor an account, which only this
+-- migration may write.
+do $$
+declare
+  opening record;
+  opening_posting_id bigint;
+begin
+  for opening in
+    select null::bigint as bank_id, id as account_id, balance_in_cents from accounts where balance_in_cents <> 0
+    union all
+    select id, null, balance_in_cents from banks where balance_in_cents <> 0
+  loop
+    insert into postings (kind) values ('opening_balance') returning id into opening_posting_id;
+
+    insert into ledger_entries (posting_id, bank_id, account_id, amount_in_cents)
+    values
+      (opening_posting_id, opening.bank_id, opening.account_id, opening.balance_in_cents),
+      (opening_posting_id, null, null, -opening.balance_in_cents);
+  end loop;
+end;
+$$;
@@ -0,0 +1,15 @@
+create table payments (
+  id bigserial primary key,
+  bank_id bigint not null references banks,
+  card_id bigint not null references cards,
+  target_account_id bigint not null references accounts,
+  amount_in_cents bigint not null check (amount_in_cents > 0),
+  challenge bytea not null unique,
+  status text not null default 'pending',
+  transfer_id bigint references transfers,
+  expires_at timestamp(0) with time zone not null,
+  created_at timestamp(0) with time zone not null default now(),
+  version bigint not null default 0
+);
+
+create index payments_bank_id_idx on payments (bank_id);
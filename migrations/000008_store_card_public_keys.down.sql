@@ -0,0 +1,6 @@
+-- This migration loses data and can't be fully reversed. The up migration kept
+-- only the public half of each card's ed25519 key, so the private_key column
+-- comes back holding 32 byte public keys instead of 64 byte private keys. Code
+-- from before this migration can't sign with them, and every card issued up to
+-- here has to be replaced after rolling back.
+ALTER TABLE cards RENAME COLUMN public_key TO private_key;
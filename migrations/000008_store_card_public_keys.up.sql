@@ -0,0 +1,3 @@
+alter table cards rename column private_key to public_key;
+
+update cards set public_key = substring(public_key from 33 for 32) where length(public_key) = 64;
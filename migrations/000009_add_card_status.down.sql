@@ -0,0 +1,3 @@
+alter table cards drop column if exists replaced_by;
+alter table cards drop column if exists blocked_reason;
+alter table cards drop column if exists status;
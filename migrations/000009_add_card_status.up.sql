@@ -0,0 +1,3 @@
+alter table cards add column status text not null default 'active';
+alter table cards add column blocked_reason text not null default '';
+alter table cards add column replaced_by bigint references cards;
@@ -0,0 +1,2 @@
+alter table cards drop column if exists locked;
+alter table cards drop column if exists failed_pin_attempts;
@@ -0,0 +1,2 @@
+alter table cards add column failed_pin_attempts integer not null default 0;
+alter table cards add column locked boolean not null default false;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS bins;
\ No newline at end of file
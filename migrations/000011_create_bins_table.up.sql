@@ -0,0 +1,9 @@
+create table bins (
+  id bigserial primary key,
+  bank_id bigint not null references banks,
+  prefix text not null unique,
+  card_length integer not null check (card_length between 12 and 18),
+  created_at timestamp(0) with time zone not null default now()
+);
+
+create index bins_bank_id_idx on bins (bank_id);
@@ -0,0 +1,14 @@
+create table idempotency_keys (
+  bank_id bigint not null references banks on delete cascade,
+  key text not null,
+  request_method text not null,
+  request_path text not null,
+  request_hash bytea not null,
+  response_status integer,
+  response_headers jsonb,
+  response_body bytea,
+  created_at timestamp(0) with time zone not null default now(),
+  primary key (bank_id, key)
+);
+
+create index idempotency_keys_created_at_idx on idempotency_keys (created_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS reserve_operations;
+DROP INDEX IF EXISTS banks_central_idx;
+ALTER TABLE banks DROP COLUMN IF EXISTS central;
\ No newline at end of file
@@ -0,0 +1,21 @@
+alter table banks add column central boolean not null default false;
+
+create unique index banks_central_idx on banks (central) where central;
+
+create table reserve_operations (
+  id bigserial primary key,
+  bank_id bigint not null references banks,
+  kind text not null check (kind in ('issuance', 'redemption')),
+  amount_in_cents bigint not null check (amount_in_cents > 0),
+  operator text not null,
+  reason text not null,
+  reference text not null,
+  posting_id bigint not null references postings,
+  created_at timestamp(0) with time zone not null default now()
+);
+
+create index reserve_operations_bank_id_idx on reserve_operations (bank_id);
+
+create trigger reserve_operations_append_only
+  before update or delete on reserve_operations
+  for each row execute function reject_ledger_change();
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS account_depositors;
+DROP TABLE IF EXISTS depositors;
\ No newline at end of file
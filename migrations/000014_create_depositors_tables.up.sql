@@ -0,0 +1,19 @@
+create table depositors (
+  id bigserial primary key,
+  bank_id bigint not null references banks,
+  name text not null,
+  email citext not null,
+  external_id text not null default '',
+  created_at timestamp(0) with time zone not null default now(),
+  version bigint not null default 0
+);
+
+create index depositors_bank_id_idx on depositors (bank_id);
+
+create table account_depositors (
+  account_id bigint not null references accounts on delete cascade,
+  depositor_id bigint not null references depositors on delete cascade,
+  primary key (account_id, depositor_id)
+);
+
+create index account_depositors_depositor_id_idx on account_depositors (depositor_id);
@@ -0,0 +1,2 @@
+ALTER TABLE depositors DROP COLUMN IF EXISTS kyc_tier;
+DROP TABLE IF EXISTS kyc_tiers;
\ No newline at end of file
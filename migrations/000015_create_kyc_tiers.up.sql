@@ -0,0 +1,14 @@
+create table kyc_tiers (
+  tier text primary key,
+  rank integer not null unique,
+  max_balance_in_cents bigint not null default 0 check (max_balance_in_cents >= 0),
+  max_transaction_in_cents bigint not null default 0 check (max_transaction_in_cents >= 0),
+  version bigint not null default 0
+);
+
+insert into kyc_tiers (tier, rank, max_balance_in_cents, max_transaction_in_cents) values
+  ('anonymous', 0, 30000, 10000),
+  ('basic', 1, 500000, 100000),
+  ('full', 2, 0, 0);
+
+alter table depositors add column kyc_tier text not null default 'anonymous' references kyc_tiers;
@@ -0,0 +1,4 @@
+ALTER TABLE transfers DROP COLUMN IF EXISTS overflow_in_cents;
+ALTER TABLE transfers DROP COLUMN IF EXISTS overflow_account_id;
+ALTER TABLE accounts DROP COLUMN IF EXISTS overflow_account_id;
+ALTER TABLE accounts DROP COLUMN IF EXISTS holding_limit_in_cents;
\ No newline at end of file
@@ -0,0 +1,5 @@
+alter table accounts add column holding_limit_in_cents bigint not null default 0 check (holding_limit_in_cents >= 0);
+alter table accounts add column overflow_account_id bigint references accounts check (overflow_account_id <> id);
+
+alter table transfers add column overflow_account_id bigint references accounts;
+alter table transfers add column overflow_in_cents bigint not null default 0 check (overflow_in_cents >= 0 and overflow_in_cents <= amount_in_cents);
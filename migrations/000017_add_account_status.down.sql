@@ -0,0 +1,3 @@
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_closed_balance_check;
+ALTER TABLE accounts DROP COLUMN IF EXISTS closed_at;
+ALTER TABLE accounts DROP COLUMN IF EXISTS status;
@@ -0,0 +1,4 @@
+alter table accounts add column status text not null default 'active' check (status in ('active', 'closed'));
+alter table accounts add column closed_at timestamp(0) with time zone;
+
+alter table accounts add constraint accounts_closed_balance_check check (status <> 'closed' or balance_in_cents = 0);
@@ -0,0 +1,4 @@
+ALTER TABLE accounts ADD COLUMN frozen boolean NOT NULL DEFAULT false;
+UPDATE accounts SET frozen = true WHERE id IN (SELECT account_id FROM holds WHERE kind = 'freeze' AND released_at IS NULL);
+DROP TABLE IF EXISTS hold_events;
+DROP TABLE IF EXISTS holds;
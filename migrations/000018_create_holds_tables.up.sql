@@ -0,0 +1,41 @@
+create table holds (
+  id bigserial primary key,
+  account_id bigint not null references accounts,
+  kind text not null check (kind in ('freeze', 'amount')),
+  amount_in_cents bigint not null default 0,
+  reason_code text not null,
+  authority text not null,
+  starts_at timestamp(0) with time zone not null default now(),
+  expires_at timestamp(0) with time zone,
+  released_at timestamp(0) with time zone,
+  created_at timestamp(0) with time zone not null default now(),
+  version bigint not null default 0,
+  check ((kind = 'freeze' and amount_in_cents = 0) or (kind = 'amount' and amount_in_cents > 0)),
+  check (expires_at is null or expires_at > starts_at)
+);
+
+create index holds_account_id_idx on holds (account_id);
+
+create table hold_events (
+  id bigserial primary key,
+  hold_id bigint not null references holds,
+  action text not null check (action in ('placed', 'released')),
+  note text not null default '',
+  created_at timestamp(0) with time zone not null default now()
+);
+
+create index hold_events_hold_id_idx on hold_events (hold_id);
+
+create trigger hold_events_append_only
+  before update or delete on hold_events
+  for each row execute function reject_ledger_change();
+
+with migrated as (
+  insert into holds (account_id, kind, reason_code, authority)
+  select id, 'freeze', 'other', 'bank' from accounts where frozen
+  returning id
+)
+insert into hold_events (hold_id, action, note)
+select id, 'placed', 'migrated from frozen account flag' from migrated;
+
+alter table accounts drop column frozen;
@@ -0,0 +1,3 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS authorization_id;
+ALTER TABLE payments DROP COLUMN IF EXISTS capture;
+DROP TABLE IF EXISTS authorizations;
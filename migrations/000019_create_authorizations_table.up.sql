@@ -0,0 +1,20 @@
+create table authorizations (
+  id bigserial primary key,
+  bank_id bigint not null references banks,
+  card_id bigint not null references cards,
+  account_id bigint not null references accounts,
+  target_account_id bigint not null references accounts,
+  amount_in_cents bigint not null check (amount_in_cents > 0),
+  captured_in_cents bigint not null default 0 check (captured_in_cents >= 0 and captured_in_cents <= amount_in_cents),
+  status text not null default 'pending' check (status in ('pending', 'captured', 'voided')),
+  transfer_id bigint references transfers,
+  expires_at timestamp(0) with time zone not null,
+  created_at timestamp(0) with time zone not null default now(),
+  version bigint not null default 0
+);
+
+create index authorizations_bank_id_idx on authorizations (bank_id);
+create index authorizations_pending_account_id_idx on authorizations (account_id) where status = 'pending';
+
+alter table payments add column capture boolean not null default true;
+alter table payments add column authorization_id bigint references authorizations;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS transfers_refund_of_transfer_id_idx;
+ALTER TABLE transfers DROP COLUMN IF EXISTS refunded_in_cents;
+ALTER TABLE transfers DROP COLUMN IF EXISTS refund_reason;
+ALTER TABLE transfers DROP COLUMN IF EXISTS refund_of_transfer_id;
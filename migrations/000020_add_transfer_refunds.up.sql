@@ -0,0 +1,5 @@
+alter table transfers add column refund_of_transfer_id bigint references transfers;
+alter table transfers add column refund_reason text not null default '';
+alter table transfers add column refunded_in_cents bigint not null default 0 check (refunded_in_cents >= 0 and refunded_in_cents <= amount_in_cents);
+
+create index transfers_refund_of_transfer_id_idx on transfers (refund_of_transfer_id);
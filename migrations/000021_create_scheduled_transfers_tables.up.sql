@@ -0,0 +1,42 @@
+create table scheduled_transfers (
+  id bigserial primary key,
+  bank_id bigint not null references banks,
+  source_account_id bigint not null references accounts,
+  target_account_id bigint not null references accounts,
+  amount_in_cents bigint not null check (amount_in_cents > 0),
+  description text not null default '',
+  frequency text not null check (frequency in ('once', 'daily', 'weekly', 'monthly')),
+  every integer not null default 1 check (every > 0),
+  starts_at timestamp(0) with time zone not null,
+  ends_at timestamp(0) with time zone,
+  max_occurrences integer not null default 0 check (max_occurrences >= 0),
+  occurrences integer not null default 0,
+  retries integer not null default 0,
+  next_run_at timestamp(0) with time zone,
+  status text not null default 'active' check (status in ('active', 'completed', 'cancelled')),
+  created_at timestamp(0) with time zone not null default now(),
+  version bigint not null default 0,
+  check (source_account_id <> target_account_id),
+  check (ends_at is null or ends_at >= starts_at)
+);
+
+create index scheduled_transfers_bank_id_idx on scheduled_transfers (bank_id);
+create index scheduled_transfers_due_idx on scheduled_transfers (next_run_at) where status = 'active';
+
+create table scheduled_transfer_runs (
+  id bigserial primary key,
+  scheduled_transfer_id bigint not null references scheduled_transfers,
+  occurrence integer not null,
+  scheduled_for timestamp(0) with time zone not null,
+  attempt integer not null,
+  status text not null check (status in ('succeeded', 'retrying', 'failed')),
+  transfer_id bigint references transfers,
+  error text not null default '',
+  created_at timestamp(0) with time zone not null default now()
+);
+
+create index scheduled_transfer_runs_scheduled_transfer_id_idx on scheduled_transfer_runs (scheduled_transfer_id);
+
+create trigger scheduled_transfer_runs_append_only
+  before update or delete on scheduled_transfer_runs
+  for each row execute function reject_ledger_change();
@@ -0,0 +1,5 @@
+ALTER TABLE banks ADD COLUMN central boolean NOT NULL DEFAULT false;
+UPDATE banks SET central = true WHERE role = 'central_bank';
+DROP INDEX IF EXISTS banks_central_idx;
+CREATE UNIQUE INDEX banks_central_idx ON banks (central) WHERE central;
+ALTER TABLE banks DROP COLUMN IF EXISTS role;
@@ -0,0 +1,8 @@
+alter table banks add column role text not null default 'commercial_bank' check (role in ('central_bank', 'commercial_bank', 'psp'));
+
+update banks set role = 'central_bank' where central;
+
+drop index banks_central_idx;
+alter table banks drop column central;
+
+create unique index banks_central_idx on banks (role) where role = 'central_bank';
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS bank_freeze_events;
@@ -0,0 +1,14 @@
+create table bank_freeze_events (
+  id bigserial primary key,
+  bank_id bigint not null references banks,
+  action text not null check (action in ('frozen', 'unfrozen')),
+  reason text not null,
+  actor_bank_id bigint not null references banks,
+  created_at timestamp(0) with time zone not null default now()
+);
+
+create index bank_freeze_events_bank_id_idx on bank_freeze_events (bank_id);
+
+create trigger bank_freeze_events_append_only
+  before update or delete on bank_freeze_events
+  for each row execute function reject_ledger_change();
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS audit_events;
+DELETE FROM tokens WHERE user_id IS NOT NULL;
+ALTER TABLE tokens DROP COLUMN IF EXISTS user_id;
+DROP TABLE IF EXISTS users;
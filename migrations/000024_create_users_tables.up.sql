@@ -0,0 +1,33 @@
+create table users (
+  id bigserial primary key,
+  bank_id bigint not null references banks,
+  name text not null,
+  email citext not null unique,
+  password_hash bytea not null,
+  role text not null check (role in ('read_only', 'operator', 'admin')),
+  activated bool not null default false,
+  disabled bool not null default false,
+  created_at timestamp(0) with time zone not null default now(),
+  version bigint not null default 0
+);
+
+create index users_bank_id_idx on users (bank_id);
+
+alter table tokens add column user_id bigint references users on delete cascade;
+
+create table audit_events (
+  id bigserial primary key,
+  bank_id bigint not null references banks,
+  user_id bigint references users,
+  method text not null,
+  path text not null,
+  status integer not null,
+  created_at timestamp(0) with time zone not null default now()
+);
+
+create index audit_events_bank_id_idx on audit_events (bank_id);
+create index audit_events_user_id_idx on audit_events (user_id);
+
+create trigger audit_events_append_only
+  before update or delete on audit_events
+  for each row execute function reject_ledger_change();
@@ -0,0 +1,4 @@
+ALTER TABLE transfers DROP COLUMN IF EXISTS user_id;
+ALTER TABLE bank_freeze_events DROP COLUMN IF EXISTS actor_user_id;
+ALTER TABLE hold_events DROP COLUMN IF EXISTS user_id;
+ALTER TABLE reserve_operations DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,4 @@
+alter table reserve_operations add column user_id bigint references users;
+alter table hold_events add column user_id bigint references users;
+alter table bank_freeze_events add column actor_user_id bigint references users;
+alter table transfers add column user_id bigint references users;